@@ -0,0 +1,154 @@
+package gotor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func archiveFormat(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("download")) {
+	case "zip":
+		return "zip"
+	case "tar.gz", "tgz":
+		return "tar.gz"
+	}
+	return ""
+}
+
+func walkArchive(fsys fs.FS, name string, hideDotfiles bool, fn func(rel string, fi fs.FileInfo, f fs.File) error) error {
+	return fs.WalkDir(fsys, name, func(pth string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if pth == name {
+				return err
+			}
+			return nil
+		}
+		if pth == name {
+			return nil
+		}
+		rel := strings.TrimPrefix(pth, name+"/")
+		if name == "." {
+			rel = pth
+		}
+		if hideDotfiles && isDotfile(de.Name()) {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if de.IsDir() {
+			fi, err := de.Info()
+			if err != nil {
+				return nil
+			}
+			return fn(rel, fi, nil)
+		}
+		f, err := fsys.Open(pth)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		return fn(rel, fi, f)
+	})
+}
+
+func copyArchiveEntry(dst io.Writer, f fs.File, size int64) error {
+	n, err := io.CopyN(dst, f, size)
+	if err == io.EOF {
+		_, err = io.CopyN(dst, zeroReader{}, size-n)
+	}
+	return err
+}
+
+func (fsv *FileServer) serveArchive(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, format string) {
+	base := path.Base(name)
+	if name == "." {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		base = strings.Trim(host, "[]")
+		if len(base) == 0 {
+			base = "archive"
+		}
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	if format == "zip" {
+		h.Set("Content-Type", "application/zip")
+	} else {
+		h.Set("Content-Type", "application/gzip")
+	}
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base + "." + format}))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if format == "zip" {
+		zw := zip.NewWriter(w)
+		walkArchive(fsys, name, fsv.HideDotfiles, func(rel string, fi fs.FileInfo, f fs.File) error {
+			zh, err := zip.FileInfoHeader(fi)
+			if err != nil {
+				return err
+			}
+			zh.Name = rel
+			if f == nil {
+				zh.Name += "/"
+				_, err = zw.CreateHeader(zh)
+				return err
+			}
+			zh.Method = zip.Deflate
+			zf, err := zw.CreateHeader(zh)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(zf, f)
+			return err
+		})
+		zw.Close()
+		return
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	walkArchive(fsys, name, fsv.HideDotfiles, func(rel string, fi fs.FileInfo, f fs.File) error {
+		th, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		th.Name = rel
+		if f == nil {
+			th.Name += "/"
+		}
+		if err := tw.WriteHeader(th); err != nil {
+			return err
+		}
+		if f == nil {
+			return nil
+		}
+		return copyArchiveEntry(tw, f, th.Size)
+	})
+	tw.Close()
+	gw.Close()
+}
@@ -0,0 +1,231 @@
+package gotor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+type encodedFS interface {
+	openEncoded(r *http.Request, name string) *encodedFile
+}
+
+type ArchiveFS interface {
+	fs.FS
+	io.Closer
+}
+
+func OpenArchiveFS(archivePath string) (ArchiveFS, error) {
+	if strings.EqualFold(path.Ext(archivePath), ".tar") {
+		return openTarFS(archivePath)
+	}
+	return openZipFS(archivePath)
+}
+
+func ArchiveService(archivePath string, cacheAge int64, responseName bool, enableIndex bool) http.HandlerFunc {
+	afs, err := OpenArchiveFS(archivePath)
+	if err != nil {
+		return nil
+	}
+	return FSFileService(afs, cacheAge, responseName, enableIndex)
+}
+
+type zipFS struct {
+	*zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func openZipFS(archivePath string) (*zipFS, error) {
+	zrc, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	zfs := &zipFS{zrc, map[string]*zip.File{}}
+	for _, zf := range zrc.File {
+		if name := path.Clean(strings.TrimPrefix(zf.Name, "/")); fs.ValidPath(name) {
+			zfs.files[name] = zf
+		}
+	}
+	return zfs, nil
+}
+
+type rawFile struct {
+	*io.SectionReader
+	fi fs.FileInfo
+}
+
+func (rf *rawFile) Stat() (fs.FileInfo, error) {
+	return rf.fi, nil
+}
+
+func (rf *rawFile) Close() error {
+	return nil
+}
+
+type sizedFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (sfi *sizedFileInfo) Size() int64 {
+	return sfi.size
+}
+
+// Stored deflate streams are raw RFC 1951 data rather than the zlib wrapper
+// the "deflate" coding names, which every major browser accepts anyway.
+func (zfs *zipFS) openEncoded(r *http.Request, name string) *encodedFile {
+	zf, ok := zfs.files[name]
+	if !ok || zf.Method != zip.Deflate || !acceptsEncoding(r, "deflate") {
+		return nil
+	}
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil
+	}
+	sr, ok := raw.(*io.SectionReader)
+	if !ok {
+		return nil
+	}
+	fi := &sizedFileInfo{zf.FileInfo(), int64(zf.CompressedSize64)}
+	return &encodedFile{"deflate", ".deflate", &rawFile{sr, fi}, fi}
+}
+
+type tarEntry struct {
+	hdr      *tar.Header
+	name     string
+	offset   int64
+	children []string
+}
+
+type tarFS struct {
+	f       *os.File
+	entries map[string]*tarEntry
+}
+
+func openTarFS(archivePath string) (*tarFS, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	tfs := &tarFS{f, map[string]*tarEntry{".": {hdr: &tar.Header{Typeflag: tar.TypeDir, Mode: 0755}, name: "."}}}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			continue
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		tfs.add(name, hdr, offset)
+	}
+	for _, te := range tfs.entries {
+		sort.Strings(te.children)
+	}
+	return tfs, nil
+}
+
+func (tfs *tarFS) add(name string, hdr *tar.Header, offset int64) {
+	if te, ok := tfs.entries[name]; ok {
+		if te.hdr.Typeflag == tar.TypeDir && hdr.Typeflag == tar.TypeDir {
+			te.hdr = hdr
+			return
+		}
+		te.hdr, te.offset = hdr, offset
+		return
+	}
+	tfs.entries[name] = &tarEntry{hdr: hdr, name: name, offset: offset}
+	dir := path.Dir(name)
+	if _, ok := tfs.entries[dir]; !ok {
+		tfs.add(dir, &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: hdr.ModTime}, 0)
+	}
+	parent := tfs.entries[dir]
+	parent.children = append(parent.children, name)
+}
+
+func (tfs *tarFS) Close() error {
+	return tfs.f.Close()
+}
+
+func (tfs *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	te, ok := tfs.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if te.hdr.Typeflag == tar.TypeDir {
+		return &tarDir{tfs: tfs, te: te}, nil
+	}
+	return &rawFile{io.NewSectionReader(tfs.f, te.offset, te.hdr.Size), te.info()}, nil
+}
+
+type tarFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (tfi *tarFileInfo) Name() string {
+	return tfi.name
+}
+
+func (te *tarEntry) info() fs.FileInfo {
+	return &tarFileInfo{te.hdr.FileInfo(), path.Base(te.name)}
+}
+
+type tarDir struct {
+	tfs *tarFS
+	te  *tarEntry
+	pos int
+}
+
+func (td *tarDir) Stat() (fs.FileInfo, error) {
+	return td.te.info(), nil
+}
+
+func (td *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: td.te.name, Err: errors.New("is a directory")}
+}
+
+func (td *tarDir) Close() error {
+	return nil
+}
+
+func (td *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remain := td.te.children[td.pos:]
+	if n > 0 && len(remain) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remain) {
+		remain = remain[:n]
+	}
+	des := make([]fs.DirEntry, 0, len(remain))
+	for _, child := range remain {
+		des = append(des, fs.FileInfoToDirEntry(td.tfs.entries[child].info()))
+	}
+	td.pos += len(remain)
+	return des, nil
+}
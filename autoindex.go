@@ -0,0 +1,178 @@
+package gotor
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DirEntry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type DirListing struct {
+	Path    string     `json:"path"`
+	Sort    string     `json:"sort"`
+	Order   string     `json:"order"`
+	Entries []DirEntry `json:"entries"`
+}
+
+var DefaultIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"sortURL": func(l *DirListing, by string) string {
+		order := "asc"
+		if l.Sort == by && l.Order == "asc" {
+			order = "desc"
+		}
+		return "?sort=" + by + "&order=" + order
+	},
+	"size": func(e DirEntry) string {
+		if e.IsDir {
+			return "-"
+		}
+		return formatSize(e.Size)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of {{.Path}}</title>
+<style>
+body{font-family:sans-serif;margin:2em}
+table{border-collapse:collapse}
+th,td{padding:.25em 1em;text-align:left}
+td.size{text-align:right}
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th><a href="{{sortURL . "name"}}">Name</a></th><th><a href="{{sortURL . "size"}}">Size</a></th><th><a href="{{sortURL . "time"}}">Modified</a></th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td class="size">-</td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{size .}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func formatSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	d, exp := int64(1024), 0
+	for m := n / 1024; m >= 1024 && exp < len(units)-1; m /= 1024 {
+		d *= 1024
+		exp++
+	}
+	return strconv.FormatInt(n/d, 10) + "." + strconv.FormatInt(n%d*10/d, 10) + " " + units[exp:exp+1] + "iB"
+}
+
+func isDotfile(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if len(seg) > 1 && seg[0] == '.' && seg != ".." && seg != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+func readDirListing(fsys fs.FS, name string, urlPath string, hideDotfiles bool) (*DirListing, error) {
+	des, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	l := &DirListing{Path: urlPath, Entries: []DirEntry{}}
+	for _, de := range des {
+		if hideDotfiles && isDotfile(de.Name()) {
+			continue
+		}
+		fi, err := fs.Stat(fsys, path.Join(name, de.Name()))
+		if err != nil {
+			continue
+		}
+		e := DirEntry{
+			Name:    de.Name(),
+			URL:     (&url.URL{Path: de.Name()}).EscapedPath(),
+			IsDir:   fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if strings.Contains(e.Name, ":") {
+			e.URL = "./" + e.URL
+		}
+		if e.IsDir {
+			e.URL += "/"
+			e.Size = 0
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	return l, nil
+}
+
+func (l *DirListing) sortBy(by string, order string) {
+	if order != "desc" {
+		order = "asc"
+	}
+	var less func(a, b *DirEntry) bool
+	switch by {
+	case "size":
+		less = func(a, b *DirEntry) bool { return a.Size < b.Size }
+	case "time":
+		less = func(a, b *DirEntry) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		by = "name"
+		less = func(a, b *DirEntry) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	}
+	l.Sort, l.Order = by, order
+	sort.SliceStable(l.Entries, func(i, j int) bool {
+		a, b := &l.Entries[i], &l.Entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if order == "desc" {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+func (fsv *FileServer) serveDirListing(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	l, err := readDirListing(fsys, name, r.URL.Path, fsv.HideDotfiles)
+	if err != nil {
+		Error(w, r, errorStatus(err))
+		return
+	}
+	q := r.URL.Query()
+	l.sortBy(q.Get("sort"), q.Get("order"))
+
+	w.Header().Set("Cache-Control", "no-cache")
+	addVary(w.Header(), "Accept")
+	w.Header().Set("Content-Encoding", "br")
+
+	if prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(l)
+		return
+	}
+
+	tmpl := fsv.IndexTemplate
+	if tmpl == nil {
+		tmpl = DefaultIndexTemplate
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	tmpl.Execute(w, l)
+}
@@ -0,0 +1,142 @@
+package gotor
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type CacheRule struct {
+	Pattern     string
+	Regexp      *regexp.Regexp
+	ContentType string
+
+	MaxAge               int64
+	SharedMaxAge         int64
+	StaleWhileRevalidate int64
+	StaleIfError         int64
+	Immutable            bool
+	NoCache              bool
+	NoStore              bool
+	Private              bool
+	Public               bool
+	MustRevalidate       bool
+}
+
+var globRegexps sync.Map
+
+func globRegexp(pattern string) *regexp.Regexp {
+	if re, ok := globRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "[hash]"):
+			sb.WriteString("([0-9A-Za-z_-]{8,})")
+			i += len("[hash]") - 1
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re := regexp.MustCompile(sb.String())
+	globRegexps.Store(pattern, re)
+	return re
+}
+
+func globMatch(pattern string, s string) bool {
+	m := globRegexp(pattern).FindStringSubmatch(s)
+	if m == nil {
+		return false
+	}
+	for _, hash := range m[1:] {
+		if !strings.ContainsAny(hash, "0123456789") {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *CacheRule) match(name string, contType string) bool {
+	name = "/" + strings.TrimPrefix(name, "/")
+	if len(cr.Pattern) > 0 {
+		target := name
+		if !strings.Contains(cr.Pattern, "/") {
+			target = name[strings.LastIndexByte(name, '/')+1:]
+		}
+		if !globMatch(cr.Pattern, target) {
+			return false
+		}
+	}
+	if cr.Regexp != nil && !cr.Regexp.MatchString(name) {
+		return false
+	}
+	if len(cr.ContentType) > 0 {
+		mt := mediaType(contType)
+		ct := strings.ToLower(cr.ContentType)
+		if major, ok := strings.CutSuffix(ct, "/*"); ok {
+			if !strings.HasPrefix(mt, major+"/") {
+				return false
+			}
+		} else if mt != ct {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *CacheRule) String() string {
+	if cr.NoStore {
+		return "no-store"
+	}
+	var ds []string
+	if cr.Private {
+		ds = append(ds, "private")
+	} else if cr.Public {
+		ds = append(ds, "public")
+	}
+	if cr.NoCache {
+		ds = append(ds, "no-cache")
+	}
+	if cr.MaxAge > 0 || !cr.NoCache {
+		ds = append(ds, "max-age="+strconv.FormatInt(cr.MaxAge, 10))
+	}
+	if cr.SharedMaxAge > 0 {
+		ds = append(ds, "s-maxage="+strconv.FormatInt(cr.SharedMaxAge, 10))
+	}
+	if cr.MustRevalidate {
+		ds = append(ds, "must-revalidate")
+	}
+	if cr.Immutable {
+		ds = append(ds, "immutable")
+	}
+	if cr.StaleWhileRevalidate > 0 {
+		ds = append(ds, "stale-while-revalidate="+strconv.FormatInt(cr.StaleWhileRevalidate, 10))
+	}
+	if cr.StaleIfError > 0 {
+		ds = append(ds, "stale-if-error="+strconv.FormatInt(cr.StaleIfError, 10))
+	}
+	return strings.Join(ds, ", ")
+}
+
+func (fsv *FileServer) cacheControl(name string, contType string) string {
+	for i := range fsv.CacheRules {
+		if fsv.CacheRules[i].match(name, contType) {
+			return fsv.CacheRules[i].String()
+		}
+	}
+	if fsv.CacheAge >= 0 {
+		return "max-age=" + strconv.FormatInt(fsv.CacheAge, 10)
+	}
+	return "no-store"
+}
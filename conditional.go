@@ -0,0 +1,207 @@
+package gotor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type condResult int
+
+const (
+	condNone condResult = iota
+	condTrue
+	condFalse
+)
+
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+func fileETag(fi fs.FileInfo) string {
+	return "\"" + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36) + "\""
+}
+
+func hashETag(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return "\"" + hex.EncodeToString(h.Sum(nil)[:16]) + "\"", nil
+}
+
+func weakETag(etag string) string {
+	if len(etag) == 0 || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+func scanETag(s string) (etag string, remain string) {
+	s = textproto.TrimString(s)
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0x21 || c >= 0x23 && c <= 0x7E || c >= 0x80:
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+func etagStrongMatch(a, b string) bool {
+	return a == b && len(a) > 0 && a[0] == '"'
+}
+
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func checkETagList(hdr string, etag string, match func(a, b string) bool) condResult {
+	for {
+		hdr = textproto.TrimString(hdr)
+		if len(hdr) == 0 {
+			return condFalse
+		}
+		if hdr[0] == ',' {
+			hdr = hdr[1:]
+			continue
+		}
+		if hdr[0] == '*' {
+			return condTrue
+		}
+		et, remain := scanETag(hdr)
+		if et == "" {
+			return condFalse
+		}
+		if match(et, etag) {
+			return condTrue
+		}
+		hdr = remain
+	}
+}
+
+func checkIfMatch(r *http.Request, etag string) condResult {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return condNone
+	}
+	return checkETagList(im, etag, etagStrongMatch)
+}
+
+func checkIfNoneMatch(r *http.Request, etag string) condResult {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return condNone
+	}
+	if checkETagList(inm, etag, etagWeakMatch) == condTrue {
+		return condFalse
+	}
+	return condTrue
+}
+
+func checkIfUnmodifiedSince(r *http.Request, modtime time.Time) condResult {
+	ius := r.Header.Get("If-Unmodified-Since")
+	if ius == "" || isZeroTime(modtime) {
+		return condNone
+	}
+	t, err := http.ParseTime(ius)
+	if err != nil {
+		return condNone
+	}
+	if !modtime.Truncate(time.Second).After(t) {
+		return condTrue
+	}
+	return condFalse
+}
+
+func checkIfModifiedSince(r *http.Request, modtime time.Time) condResult {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return condNone
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || isZeroTime(modtime) {
+		return condNone
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return condNone
+	}
+	if !modtime.Truncate(time.Second).After(t) {
+		return condFalse
+	}
+	return condTrue
+}
+
+func checkIfRange(r *http.Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	et, _ := scanETag(ir)
+	if et != "" {
+		return etagStrongMatch(et, etag)
+	}
+	if isZeroTime(modtime) {
+		return false
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	return t.Unix() == modtime.Unix()
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, modtime time.Time) bool {
+	ch := checkIfMatch(r, etag)
+	if ch == condNone {
+		ch = checkIfUnmodifiedSince(r, modtime)
+	}
+	if ch == condFalse {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	}
+	switch checkIfNoneMatch(r, etag) {
+	case condFalse:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			writeNotModified(w)
+			return true
+		}
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	case condNone:
+		if checkIfModifiedSince(r, modtime) == condFalse {
+			writeNotModified(w)
+			return true
+		}
+	}
+	return false
+}
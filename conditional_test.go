@@ -0,0 +1,84 @@
+package gotor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileServerConditional(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("compressible text ", 64)
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+	fsv := &FileServer{Root: dir}
+	serve := func(hdr map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		fsv.ServeHTTP(rec, r)
+		return rec
+	}
+
+	strong := serve(nil).Header().Get("ETag")
+	if !strings.HasPrefix(strong, "\"") {
+		t.Fatalf("identity ETag %q is not strong", strong)
+	}
+	compressed := serve(map[string]string{"Accept-Encoding": "gzip"}).Header().Get("ETag")
+	if compressed != weakETag(strong) {
+		t.Fatalf("compressed ETag %q, want %q", compressed, weakETag(strong))
+	}
+	lastModified := modtime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		hdr    map[string]string
+		status int
+	}{
+		{"If-None-Match weak on compressed", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": compressed}, 304},
+		{"If-None-Match strong on compressed", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": strong}, 304},
+		{"If-None-Match weak on identity", map[string]string{"If-None-Match": compressed}, 304},
+		{"If-None-Match list", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"x", ` + compressed}, 304},
+		{"If-None-Match star", map[string]string{"If-None-Match": "*"}, 304},
+		{"If-None-Match other", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `W/"x"`}, 200},
+		{"If-Match strong", map[string]string{"If-Match": strong}, 200},
+		{"If-Match weak", map[string]string{"If-Match": compressed}, 412},
+		{"If-Match other", map[string]string{"If-Match": `"x"`}, 412},
+		{"If-Range strong", map[string]string{"Range": "bytes=0-3", "If-Range": strong}, 206},
+		{"If-Range weak", map[string]string{"Range": "bytes=0-3", "If-Range": compressed}, 200},
+		{"If-Range other", map[string]string{"Range": "bytes=0-3", "If-Range": `"x"`}, 200},
+		{"If-Range date", map[string]string{"Range": "bytes=0-3", "If-Range": lastModified}, 206},
+		{"If-Range old date", map[string]string{"Range": "bytes=0-3", "If-Range": modtime.Add(-time.Hour).Format(http.TimeFormat)}, 200},
+		{"If-Modified-Since", map[string]string{"If-Modified-Since": lastModified}, 304},
+		{"If-Modified-Since old", map[string]string{"If-Modified-Since": modtime.Add(-time.Hour).Format(http.TimeFormat)}, 200},
+		{"If-None-Match overrides If-Modified-Since", map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": lastModified}, 200},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.hdr)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		switch rec.Code {
+		case http.StatusNotModified:
+			if rec.Body.Len() != 0 || len(rec.Header().Get("ETag")) == 0 {
+				t.Errorf("%s: body %d bytes, ETag %q", tt.name, rec.Body.Len(), rec.Header().Get("ETag"))
+			}
+		case http.StatusPartialContent:
+			if rec.Body.String() != content[:4] {
+				t.Errorf("%s: body %q", tt.name, rec.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package gotor
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const maxPreloadScan = 64 << 10
+
+type preloadCacheEntry struct {
+	modTime  time.Time
+	size     int64
+	links    []string
+	manifest map[string][]string
+}
+
+func isHTMLDoc(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm", ".shtml":
+		return true
+	}
+	return false
+}
+
+func preloadLink(href string, rel string, as string) string {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || len(u.String()) == 0 || (len(u.Scheme) > 0 && u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	l := "<" + u.String() + ">; rel=" + rel
+	if len(as) > 0 {
+		l += "; as=" + as
+	}
+	if as == "font" {
+		l += "; crossorigin"
+	}
+	return l
+}
+
+func scanPreloads(r io.Reader) []string {
+	links := []string{}
+	z := html.NewTokenizer(io.LimitReader(r, maxPreloadScan))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return links
+			}
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+		default:
+			continue
+		}
+		name, hasAttr := z.TagName()
+		attrs := map[string]string{}
+		for hasAttr {
+			var k, v []byte
+			k, v, hasAttr = z.TagAttr()
+			attrs[string(k)] = string(v)
+		}
+		var l string
+		switch string(name) {
+		case "body":
+			return links
+		case "link":
+			switch rel := strings.ToLower(attrs["rel"]); rel {
+			case "stylesheet":
+				l = preloadLink(attrs["href"], "preload", "style")
+			case "preload":
+				if as := strings.ToLower(attrs["as"]); as == "style" || as == "script" || as == "font" {
+					l = preloadLink(attrs["href"], "preload", as)
+				}
+			case "modulepreload":
+				l = preloadLink(attrs["href"], "modulepreload", "")
+			}
+		case "script":
+			if _, async := attrs["async"]; async || len(attrs["src"]) == 0 {
+				break
+			}
+			if strings.ToLower(attrs["type"]) == "module" {
+				l = preloadLink(attrs["src"], "modulepreload", "")
+			} else {
+				l = preloadLink(attrs["src"], "preload", "script")
+			}
+		}
+		if len(l) > 0 {
+			links = append(links, l)
+		}
+	}
+}
+
+func (fsv *FileServer) manifestPreloads(fsys fs.FS, r *http.Request, name string) []string {
+	fi, err := fs.Stat(fsys, fsName(fsv.PreloadManifest))
+	if err != nil {
+		return nil
+	}
+	var manifest map[string][]string
+	if v, ok := fsv.preloadCache.Load(fsv.PreloadManifest); ok && v.(*preloadCacheEntry).modTime.Equal(fi.ModTime()) && v.(*preloadCacheEntry).size == fi.Size() {
+		manifest = v.(*preloadCacheEntry).manifest
+	} else {
+		b, err := fs.ReadFile(fsys, fsName(fsv.PreloadManifest))
+		if err != nil || json.Unmarshal(b, &manifest) != nil {
+			return nil
+		}
+		fsv.preloadCache.Store(fsv.PreloadManifest, &preloadCacheEntry{modTime: fi.ModTime(), size: fi.Size(), manifest: manifest})
+	}
+	assets, ok := manifest[r.URL.Path]
+	if !ok {
+		assets = manifest["/"+name]
+	}
+	links := []string{}
+	for _, a := range assets {
+		as := ""
+		switch strings.ToLower(path.Ext(strings.SplitN(a, "?", 2)[0])) {
+		case ".css":
+			as = "style"
+		case ".js", ".mjs":
+			as = "script"
+		case ".woff2", ".woff", ".ttf", ".otf":
+			as = "font"
+		}
+		if l := preloadLink(a, "preload", as); len(l) > 0 {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+func (fsv *FileServer) preloads(fsys fs.FS, r *http.Request, name string, fi fs.FileInfo) []string {
+	if len(fsv.PreloadManifest) > 0 {
+		return fsv.manifestPreloads(fsys, r, name)
+	}
+	if v, ok := fsv.preloadCache.Load(name); ok {
+		if pce := v.(*preloadCacheEntry); pce.modTime.Equal(fi.ModTime()) && pce.size == fi.Size() {
+			return pce.links
+		}
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	links := scanPreloads(f)
+	if !isZeroTime(fi.ModTime()) {
+		fsv.preloadCache.Store(name, &preloadCacheEntry{modTime: fi.ModTime(), size: fi.Size(), links: links})
+	}
+	return links
+}
+
+func (fsv *FileServer) sendEarlyHints(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, fi fs.FileInfo) {
+	if r.Method != http.MethodGet || !isHTMLDoc(name) {
+		return
+	}
+	links := fsv.preloads(fsys, r, name, fi)
+	if len(links) == 0 {
+		return
+	}
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	if r.ProtoAtLeast(1, 1) {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
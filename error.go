@@ -0,0 +1,184 @@
+package gotor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+type ErrorPages map[int]http.Handler
+
+type errorPagesKey struct{}
+
+func (ep ErrorPages) Wrap(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), errorPagesKey{}, ep)))
+	}
+}
+
+type errorRespWriter struct {
+	http.ResponseWriter
+	status    int
+	isWritten bool
+}
+
+func (erw *errorRespWriter) WriteHeader(int) {
+	if erw.isWritten {
+		return
+	}
+	erw.isWritten = true
+	erw.ResponseWriter.WriteHeader(erw.status)
+}
+
+func (erw *errorRespWriter) Write(data []byte) (int, error) {
+	erw.WriteHeader(erw.status)
+	return erw.ResponseWriter.Write(data)
+}
+
+func findErrorRespWriter(w http.ResponseWriter) (*errorRespWriter, bool) {
+	for {
+		switch rw := w.(type) {
+		case *errorRespWriter:
+			return rw, true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+func Error(w http.ResponseWriter, r *http.Request, status int) {
+	if _, ok := findErrorRespWriter(w); !ok {
+		ep, _ := r.Context().Value(errorPagesKey{}).(ErrorPages)
+		h, ok := ep[status]
+		if !ok {
+			h, ok = ep[0]
+		}
+		if ok {
+			erw := &errorRespWriter{ResponseWriter: w, status: status}
+			h.ServeHTTP(erw, r)
+			erw.WriteHeader(status)
+			return
+		}
+	}
+	if status == http.StatusNotFound {
+		NotFound(w, r)
+		return
+	}
+	DefaultError(w, r, status)
+}
+
+func ErrorHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, status)
+	}
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid), errors.Is(err, syscall.ENOTDIR):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission), isPathEscape(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Error}}</title>
+</head>
+<body>
+<h1>{{.Status}} {{.Error}}</h1>
+</body>
+</html>
+`))
+
+func resetErrorHeader(h http.Header) {
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("Content-Range")
+	h.Del("Content-Disposition")
+	h.Del("Accept-Ranges")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+func DefaultError(w http.ResponseWriter, r *http.Request, status int) {
+	h := w.Header()
+	resetErrorHeader(h)
+	addVary(h, "Accept")
+
+	body := struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}{status, http.StatusText(status)}
+	if len(body.Error) == 0 {
+		body.Error = "Status " + strconv.Itoa(status)
+	}
+
+	if prefersJSON(r) {
+		h.Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	defaultErrorTemplate.Execute(w, body)
+}
+
+func ErrorFile(filePath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusInternalServerError
+		if erw, ok := findErrorRespWriter(w); ok {
+			status = erw.status
+		}
+		if prefersJSON(r) {
+			DefaultError(w, r, status)
+			return
+		}
+
+		f, err := os.Open(filePath)
+		if err != nil {
+			DefaultError(w, r, status)
+			return
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			DefaultError(w, r, status)
+			return
+		}
+
+		contType := mime.TypeByExtension(filepath.Ext(filePath))
+		if len(contType) == 0 {
+			contType = "text/html; charset=utf-8"
+		}
+
+		h := w.Header()
+		resetErrorHeader(h)
+		addVary(h, "Accept")
+		h.Set("Content-Type", contType)
+		h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			io.Copy(w, f)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package gotor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+type fileWatcher interface {
+	watch(dir string) bool
+	unwatch(dir string)
+	Close() error
+}
+
+type FileCache struct {
+	MaxSize      int64
+	MaxEntries   int
+	MaxFileSize  int64
+	PollInterval time.Duration
+
+	entries map[fileCacheKey]*cachedFile
+	lru     *list.List
+	size    int64
+	watcher fileWatcher
+	noWatch bool
+	mtx     sync.Mutex
+}
+
+type fileCacheKey struct {
+	fsv  *FileServer
+	root string
+	name string
+}
+
+type cachedFile struct {
+	key     fileCacheKey
+	osPath  string
+	watched bool
+	fi      fs.FileInfo
+	data    []byte
+	br      []byte
+	gz      []byte
+	checked time.Time
+	elem    *list.Element
+}
+
+func (cf *cachedFile) memSize() int64 {
+	return int64(len(cf.data) + len(cf.br) + len(cf.gz))
+}
+
+func (fc *FileCache) maxSize() int64 {
+	if fc.MaxSize > 0 {
+		return fc.MaxSize
+	}
+	return 64 << 20
+}
+
+func (fc *FileCache) maxEntries() int {
+	if fc.MaxEntries > 0 {
+		return fc.MaxEntries
+	}
+	return 4096
+}
+
+func (fc *FileCache) maxFileSize() int64 {
+	if fc.MaxFileSize > 0 {
+		return min(fc.MaxFileSize, fc.maxSize())
+	}
+	return fc.maxSize() / 16
+}
+
+func (fc *FileCache) pollInterval() time.Duration {
+	if fc.PollInterval > 0 {
+		return fc.PollInterval
+	}
+	return 2 * time.Second
+}
+
+func sameFileInfo(a fs.FileInfo, b fs.FileInfo) bool {
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+func (fc *FileCache) removeLocked(cf *cachedFile) {
+	if fc.entries[cf.key] != cf {
+		return
+	}
+	delete(fc.entries, cf.key)
+	fc.lru.Remove(cf.elem)
+	fc.size -= cf.memSize()
+	if cf.watched && fc.watcher != nil {
+		fc.watcher.unwatch(filepath.Dir(cf.osPath))
+	}
+}
+
+func (fc *FileCache) invalidate(dir string, name string) {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	target := filepath.Join(dir, name)
+	for _, cf := range fc.entries {
+		if !cf.watched {
+			continue
+		}
+		if len(dir) == 0 || cf.osPath == target || (len(name) == 0 && filepath.Dir(cf.osPath) == dir) {
+			fc.removeLocked(cf)
+		}
+	}
+}
+
+func (fc *FileCache) watch(osPath string) bool {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	if fc.noWatch {
+		return false
+	}
+	if fc.watcher == nil {
+		fc.watcher = newFileWatcher(fc.invalidate)
+		if fc.watcher == nil {
+			fc.noWatch = true
+			return false
+		}
+	}
+	return fc.watcher.watch(filepath.Dir(osPath))
+}
+
+func (fc *FileCache) unwatch(osPath string) {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	if fc.watcher != nil {
+		fc.watcher.unwatch(filepath.Dir(osPath))
+	}
+}
+
+func (fc *FileCache) get(fsys fs.FS, key fileCacheKey) *cachedFile {
+	fc.mtx.Lock()
+	cf, ok := fc.entries[key]
+	if !ok {
+		fc.mtx.Unlock()
+		return nil
+	}
+	fc.lru.MoveToFront(cf.elem)
+	now := time.Now()
+	needCheck := !cf.watched && now.Sub(cf.checked) >= fc.pollInterval()
+	fc.mtx.Unlock()
+
+	if needCheck {
+		fi, err := fs.Stat(fsys, key.name)
+		fc.mtx.Lock()
+		defer fc.mtx.Unlock()
+		if err != nil || !sameFileInfo(fi, cf.fi) {
+			fc.removeLocked(cf)
+			return nil
+		}
+		cf.checked = now
+	}
+	return cf
+}
+
+func compressBytes(data []byte, encoding string) []byte {
+	var buf bytes.Buffer
+	var enc io.WriteCloser
+	if encoding == "br" {
+		enc = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	} else {
+		enc, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	}
+	enc.Write(data)
+	if enc.Close() != nil || buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (fc *FileCache) put(fsys fs.FS, key fileCacheKey, osPath string, f fs.File, fi fs.FileInfo) *cachedFile {
+	rs, ok := f.(io.Seeker)
+	if !ok || !fi.Mode().IsRegular() || fi.Size() > fc.maxFileSize() || isZeroTime(fi.ModTime()) {
+		return nil
+	}
+
+	watched := false
+	if len(osPath) > 0 {
+		if lfi, err := os.Lstat(osPath); err == nil && lfi.Mode().IsRegular() {
+			watched = fc.watch(osPath)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, fi.Size()+1))
+	if err == nil && watched {
+		var lfi fs.FileInfo
+		if lfi, err = os.Lstat(osPath); err == nil && !sameFileInfo(lfi, fi) {
+			err = fs.ErrInvalid
+		}
+	}
+	if err != nil || int64(len(data)) != fi.Size() {
+		if watched {
+			fc.unwatch(osPath)
+		}
+		rs.Seek(0, io.SeekStart)
+		return nil
+	}
+
+	cf := &cachedFile{key: key, osPath: osPath, watched: watched, fi: fi, data: data, checked: time.Now()}
+	contType := mime.TypeByExtension(path.Ext(fi.Name()))
+	if len(contType) == 0 {
+		contType = http.DetectContentType(data[:min(len(data), 512)])
+	}
+	if len(data) > 0 && rawContTypes[mediaType(contType)] {
+		cf.br = compressBytes(data, "br")
+		cf.gz = compressBytes(data, "gzip")
+	}
+
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	if fc.entries == nil {
+		fc.entries = map[fileCacheKey]*cachedFile{}
+		fc.lru = list.New()
+	}
+	if old, ok := fc.entries[key]; ok {
+		fc.removeLocked(old)
+	}
+	cf.elem = fc.lru.PushFront(cf)
+	fc.entries[key] = cf
+	fc.size += cf.memSize()
+	for fc.size > fc.maxSize() || len(fc.entries) > fc.maxEntries() {
+		fc.removeLocked(fc.lru.Back().Value.(*cachedFile))
+	}
+	return cf
+}
+
+func (fc *FileCache) Purge() {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	for _, cf := range fc.entries {
+		fc.removeLocked(cf)
+	}
+}
+
+func (fc *FileCache) Close() error {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	fc.noWatch = true
+	for _, cf := range fc.entries {
+		cf.watched = false
+	}
+	if fc.watcher == nil {
+		return nil
+	}
+	err := fc.watcher.Close()
+	fc.watcher = nil
+	return err
+}
+
+func (e *rootFSEntry) osPath(name string) string {
+	if len(e.dir) == 0 {
+		return ""
+	}
+	p, err := filepath.Abs(filepath.Join(e.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+func (fsv *FileServer) cacheable(fsys fs.FS, r *http.Request, name string) bool {
+	if fsv.Cache == nil || fsv.Precompressed || len(fsv.LayerHeader) > 0 {
+		return false
+	}
+	if _, ok := fsys.(encodedFS); ok {
+		return false
+	}
+	if (fsv.Markdown && isMarkdown(name)) || (fsv.SSI && isSSI(name)) || (fsv.EarlyHints && isHTMLDoc(name)) {
+		return false
+	}
+	if fsv.CleanURLs && fsv.RedirectHTML && path.Ext(r.URL.Path) == ".html" {
+		return false
+	}
+	return len(fsv.SPAFallback) == 0 || name != fsName(fsv.SPAFallback)
+}
+
+func (fsv *FileServer) serveCached(w http.ResponseWriter, r *http.Request, name string, cf *cachedFile) {
+	var ef *encodedFile
+	brQ, gzQ := encodingQuality(r, "br"), encodingQuality(r, "gzip")
+	if len(cf.br) > 0 && brQ > 0 && brQ >= gzQ {
+		fi := &sizedFileInfo{cf.fi, int64(len(cf.br))}
+		ef = &encodedFile{"br", ".br", &rawFile{io.NewSectionReader(bytes.NewReader(cf.br), 0, fi.size), fi}, fi}
+	} else if len(cf.gz) > 0 && gzQ > 0 {
+		fi := &sizedFileInfo{cf.fi, int64(len(cf.gz))}
+		ef = &encodedFile{"gzip", ".gz", &rawFile{io.NewSectionReader(bytes.NewReader(cf.gz), 0, fi.size), fi}, fi}
+	}
+	fsv.serveFile(w, r, name, &rawFile{io.NewSectionReader(bytes.NewReader(cf.data), 0, int64(len(cf.data))), cf.fi}, cf.fi, ef)
+}
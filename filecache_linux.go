@@ -0,0 +1,118 @@
+package gotor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+type inotifyDir struct {
+	wd   int
+	refs int
+}
+
+type inotifyWatcher struct {
+	fd       int
+	f        *os.File
+	wds      map[int]string
+	dirs     map[string]*inotifyDir
+	onChange func(dir string, name string)
+	mtx      sync.Mutex
+}
+
+func newFileWatcher(onChange func(dir string, name string)) fileWatcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil
+	}
+	iw := &inotifyWatcher{
+		fd:       fd,
+		f:        os.NewFile(uintptr(fd), "inotify"),
+		wds:      map[int]string{},
+		dirs:     map[string]*inotifyDir{},
+		onChange: onChange,
+	}
+	go iw.run()
+	return iw
+}
+
+func (iw *inotifyWatcher) watch(dir string) bool {
+	iw.mtx.Lock()
+	defer iw.mtx.Unlock()
+	if d, ok := iw.dirs[dir]; ok {
+		d.refs++
+		return true
+	}
+	wd, err := syscall.InotifyAddWatch(iw.fd, dir, inotifyMask)
+	if err != nil {
+		return false
+	}
+	if _, ok := iw.wds[wd]; ok {
+		return false
+	}
+	iw.wds[wd] = dir
+	iw.dirs[dir] = &inotifyDir{wd, 1}
+	return true
+}
+
+func (iw *inotifyWatcher) unwatch(dir string) {
+	iw.mtx.Lock()
+	defer iw.mtx.Unlock()
+	d, ok := iw.dirs[dir]
+	if !ok {
+		return
+	}
+	d.refs--
+	if d.refs > 0 {
+		return
+	}
+	delete(iw.dirs, dir)
+	delete(iw.wds, d.wd)
+	syscall.InotifyRmWatch(iw.fd, uint32(d.wd))
+}
+
+func (iw *inotifyWatcher) run() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := iw.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := string(bytes.TrimRight(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+nameLen], "\x00"))
+			off += syscall.SizeofInotifyEvent + nameLen
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				iw.onChange("", "")
+				continue
+			}
+			iw.mtx.Lock()
+			dir, ok := iw.wds[wd]
+			if ok && mask&syscall.IN_IGNORED != 0 {
+				delete(iw.wds, wd)
+				delete(iw.dirs, dir)
+			}
+			iw.mtx.Unlock()
+			if !ok {
+				continue
+			}
+			if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+				name = ""
+			}
+			iw.onChange(dir, name)
+		}
+	}
+}
+
+func (iw *inotifyWatcher) Close() error {
+	return iw.f.Close()
+}
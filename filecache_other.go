@@ -0,0 +1,7 @@
+//go:build !linux
+
+package gotor
+
+func newFileWatcher(onChange func(dir string, name string)) fileWatcher {
+	return nil
+}
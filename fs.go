@@ -1,15 +1,21 @@
 package gotor
 
 import (
+	"bytes"
+	"errors"
+	"html/template"
 	"io"
 	"io/fs"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var rawContTypes = map[string]bool{
@@ -18,6 +24,7 @@ var rawContTypes = map[string]bool{
 	"text/plain":                    true,
 	"text/xml":                      true,
 	"text/x-component":              true,
+	"text/javascript":               true,
 	"application/javascript":        true,
 	"application/json":              true,
 	"application/x-javascript":      true,
@@ -33,55 +40,186 @@ var rawContTypes = map[string]bool{
 	"font/opentype":                 true,
 }
 
-func responseFile(w http.ResponseWriter, r *http.Request, f *os.File, fi fs.FileInfo, cacheAge int64, responseName bool) {
-	mod := fi.ModTime().Format(http.TimeFormat)
+type FileServer struct {
+	Root          string
+	FS            fs.FS
+	CacheAge      int64
+	ResponseName  bool
+	EnableIndex   bool
+	HashETag      bool
+	Precompressed bool
+	AutoIndex     bool
+	HideDotfiles  bool
+	IndexTemplate *template.Template
+	SPAFallback   string
+	Symlinks      SymlinkPolicy
+	IndexNames    []string
+	CleanURLs     bool
+	RedirectHTML  bool
+	CacheRules    []CacheRule
+	Archives      bool
+	Layers        []UnionLayer
+	LayerHeader   string
+	RateLimit     *RateLimit
+	Cache         *FileCache
 
-	if r.Header.Get("If-Modified-Since") == mod {
-		w.WriteHeader(http.StatusNotModified)
-		return
+	EarlyHints      bool
+	PreloadManifest string
+
+	Markdown         bool
+	MarkdownTemplate *template.Template
+	SSI              bool
+
+	rootFS       atomic.Pointer[rootFSEntry]
+	rootMtx      sync.Mutex
+	hashETags    sync.Map
+	mdCache      sync.Map
+	ssiCache     sync.Map
+	preloadCache sync.Map
+}
+
+type hashETagEntry struct {
+	statETag string
+	etag     string
+}
+
+func (fsv *FileServer) etag(name string, f io.Reader, fi fs.FileInfo) string {
+	etag := fileETag(fi)
+	if !fsv.HashETag && !isZeroTime(fi.ModTime()) {
+		return etag
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		if isZeroTime(fi.ModTime()) {
+			return weakETag(etag)
+		}
+		return etag
 	}
-	w.Header().Set("Last-Modified", mod)
+	v, ok := fsv.hashETags.Load(name)
+	if ok && v.(*hashETagEntry).statETag == etag {
+		return v.(*hashETagEntry).etag
+	}
+	het, err := hashETag(rs)
+	if _, serr := rs.Seek(0, io.SeekStart); err != nil || serr != nil {
+		return etag
+	}
+	fsv.hashETags.Store(name, &hashETagEntry{etag, het})
+	return het
+}
 
-	if cacheAge >= 0 {
-		w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(cacheAge, 10))
-	} else {
-		w.Header().Set("Cache-Control", "no-store")
+func mediaType(contType string) string {
+	ix := strings.Index(contType, ";")
+	if ix == -1 {
+		ix = len(contType)
 	}
+	return strings.ToLower(strings.TrimSpace(contType[:ix]))
+}
 
+func (fsv *FileServer) serveFile(w http.ResponseWriter, r *http.Request, name string, f fs.File, fi fs.FileInfo, ef *encodedFile) {
 	fName := fi.Name()
-	if responseName && path.Base(r.URL.Path) != fName {
+	if fsv.ResponseName && path.Base(r.URL.Path) != fName {
 		w.Header().Add("Content-Disposition", "filename=\""+fName+"\"")
 	}
 
+	var body io.Reader = f
 	var headBuf []byte
 
-	contType := mime.TypeByExtension(filepath.Ext(fName))
+	contType := w.Header().Get("Content-Type")
 	if contType == "" {
-		headBuf = make([]byte, 128)
-		n, err := f.Read(headBuf)
-		if err != nil {
-			NotFound(w, r)
+		contType = mime.TypeByExtension(path.Ext(fName))
+	}
+	if contType == "" {
+		headBuf = make([]byte, 512)
+		n, err := io.ReadFull(f, headBuf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			Error(w, r, http.StatusInternalServerError)
 			return
 		}
 		headBuf = headBuf[:n]
 		contType = http.DetectContentType(headBuf)
+		if rs, ok := f.(io.ReadSeeker); ok {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				Error(w, r, http.StatusInternalServerError)
+				return
+			}
+		} else {
+			body = io.MultiReader(bytes.NewReader(headBuf), f)
+		}
 	}
 
 	w.Header().Set("Content-Type", contType)
 
-	ix := strings.Index(contType, ";")
-	if ix == -1 {
-		ix = len(contType)
+	if len(w.Header().Get("Cache-Control")) == 0 {
+		w.Header().Set("Cache-Control", fsv.cacheControl(name, contType))
+	}
+
+	compressible := fi.Size() > 0 && rawContTypes[mediaType(contType)]
+	if compressible || fsv.Precompressed {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	if ef != nil {
+		w.Header().Set("Content-Encoding", ef.encoding)
+		body, fi, name = ef.f, ef.fi, name+ef.ext
+		compressible = false
+	}
+
+	seeker, seekable := body.(io.ReadSeeker)
+	if seekable {
+		w.Header().Set("Accept-Ranges", "bytes")
+	} else {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+
+	modtime := fi.ModTime()
+	if !isZeroTime(modtime) {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
 	}
 
 	n := fi.Size()
+	etag := w.Header().Get("ETag")
+	if len(etag) == 0 {
+		etag = fsv.etag(name, body, fi)
+	}
+	if ef != nil {
+		etag = strings.TrimSuffix(etag, "\"") + "-" + ef.encoding + "\""
+	}
+
+	var ranges []httpRange
+	var rangeErr error
+	if rh := r.Header.Get("Range"); len(rh) > 0 && n > 0 && seekable && (r.Method == http.MethodGet || r.Method == http.MethodHead) && checkIfRange(r, etag, modtime) {
+		ranges, rangeErr = parseRange(rh, n)
+		if rangeErr != nil && rangeErr != errNoOverlap || sumRangesSize(ranges) > n || ef != nil && len(ranges) > 1 {
+			ranges, rangeErr = nil, nil
+		}
+	}
+
+	if compressible && len(ranges) == 0 && rangeErr == nil && (acceptsEncoding(r, "br") || acceptsEncoding(r, "gzip")) {
+		etag = weakETag(etag)
+	}
+	w.Header().Set("ETag", etag)
+
+	if checkPreconditions(w, r, etag, modtime) {
+		return
+	}
+
+	if rangeErr == errNoOverlap {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(n, 10))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if len(ranges) > 0 {
+		responseRanges(w, r, seeker, ranges, contType, n)
+		return
+	}
+
 	if n == 0 {
 		w.Header().Set("Content-Length", "0")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if rawContTypes[strings.TrimSpace(contType[:ix])] {
+	if compressible {
 		w.Header().Set("Content-Encoding", "br")
 	} else {
 		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
@@ -89,10 +227,7 @@ func responseFile(w http.ResponseWriter, r *http.Request, f *os.File, fi fs.File
 
 	w.WriteHeader(http.StatusOK)
 
-	if headBuf != nil {
-		w.Write(headBuf)
-	}
-	io.Copy(w, f)
+	io.Copy(w, body)
 }
 
 func ResponseFile(w http.ResponseWriter, r *http.Request, filePath string, cacheAge int64, responseName bool) bool {
@@ -115,7 +250,33 @@ func ResponseFile(w http.ResponseWriter, r *http.Request, filePath string, cache
 		return false
 	}
 
-	responseFile(w, r, f, fi, cacheAge, responseName)
+	fsv := &FileServer{CacheAge: cacheAge, ResponseName: responseName}
+	fsv.serveFile(w, r, filePath, f, fi, nil)
+	return true
+}
+
+func ResponseFSFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, cacheAge int64, responseName bool) bool {
+	if !fs.ValidPath(name) {
+		return false
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	if fi.IsDir() {
+		return false
+	}
+
+	fsv := &FileServer{FS: fsys, CacheAge: cacheAge, ResponseName: responseName}
+	fsv.serveFile(w, r, name, f, fi, nil)
 	return true
 }
 
@@ -124,73 +285,277 @@ var indexFileNames = []string{
 	"index.htm",
 }
 
+func (fsv *FileServer) indexNames() []string {
+	if fsv.IndexNames != nil {
+		return fsv.IndexNames
+	}
+	return indexFileNames
+}
+
+func fsURLPath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+func (fsv *FileServer) redirectClean(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	u := url.URL{Path: fsURLPath(strings.TrimSuffix(name, ".html")), RawQuery: r.URL.RawQuery}
+	base := path.Base(u.Path)
+	isIndex := false
+	for _, indexFileName := range fsv.indexNames() {
+		if base+".html" == indexFileName {
+			u.Path = strings.TrimSuffix(u.Path, base)
+			isIndex = true
+			break
+		}
+	}
+	if !isIndex {
+		if _, err := fs.Stat(fsys, strings.TrimSuffix(name, ".html")); err == nil {
+			return false
+		}
+	}
+	Redirect(w, u.String(), http.StatusMovedPermanently)
+	return true
+}
+
 func FileService(rootDir string, cacheAge int64, responseName bool, enableIndex bool) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "..") {
-			NotFound(w, r)
-			return
+	fsv := &FileServer{
+		Root:         rootDir,
+		CacheAge:     cacheAge,
+		ResponseName: responseName,
+		EnableIndex:  enableIndex,
+	}
+	return fsv.ServeHTTP
+}
+
+func FSFileService(fsys fs.FS, cacheAge int64, responseName bool, enableIndex bool) http.HandlerFunc {
+	fsv := &FileServer{
+		FS:           fsys,
+		CacheAge:     cacheAge,
+		ResponseName: responseName,
+		EnableIndex:  enableIndex,
+	}
+	return fsv.ServeHTTP
+}
+
+type rootFSEntry struct {
+	fsys   fs.FS
+	dir    string
+	target string
+}
+
+func (fsv *FileServer) rootTarget() (string, error) {
+	if len(fsv.Layers) > 0 {
+		return "", nil
+	}
+	target, err := filepath.EvalSymlinks(fsv.Root)
+	if err != nil || target == filepath.Clean(fsv.Root) {
+		return "", nil
+	}
+	return target, nil
+}
+
+func (fsv *FileServer) root() (*rootFSEntry, error) {
+	if fsv.FS != nil {
+		return &rootFSEntry{fsys: fsv.FS}, nil
+	}
+	e := fsv.rootFS.Load()
+	if e != nil && len(e.target) == 0 {
+		return e, nil
+	}
+	target, err := fsv.rootTarget()
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && e.target == target {
+		return e, nil
+	}
+
+	fsv.rootMtx.Lock()
+	defer fsv.rootMtx.Unlock()
+	if e = fsv.rootFS.Load(); e != nil && e.target == target {
+		return e, nil
+	}
+	e = &rootFSEntry{target: target}
+	if len(fsv.Layers) > 0 {
+		e.fsys, err = NewUnionFS(fsv.Layers...)
+	} else {
+		e.dir = fsv.Root
+		if len(target) > 0 {
+			e.dir = target
 		}
+		e.fsys, err = DirFS(e.dir, fsv.Symlinks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fsv.rootFS.Store(e)
+	return e, nil
+}
 
-		pth := filepath.Join(rootDir, r.URL.Path)
+func (fsv *FileServer) fsys() (fs.FS, error) {
+	e, err := fsv.root()
+	if err != nil {
+		return nil, err
+	}
+	return e.fsys, nil
+}
 
-		var f *os.File
-		var fi fs.FileInfo
-		var err error
+func (fsv *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fsv.RateLimit != nil {
+		tw, done := fsv.RateLimit.throttle(w, r)
+		defer done()
+		w = tw
+	}
 
-		if r.URL.Path[len(r.URL.Path)-1] == '/' {
-			if !enableIndex {
-				NotFound(w, r)
+	rfs, err := fsv.root()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	fsys := rfs.fsys
+
+	name := fsName(r.URL.Path)
+	if fsv.HideDotfiles && isDotfile(name) {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+
+	if fsv.Archives {
+		if format := archiveFormat(r); len(format) > 0 {
+			if fi, err := fs.Stat(fsys, name); err == nil && fi.IsDir() {
+				fsv.serveArchive(w, r, fsys, name, format)
 				return
 			}
+		}
+	}
 
-			for _, indexFileName := range indexFileNames {
-				f, err = os.Open(filepath.Join(pth, indexFileName))
-				if err == nil {
-					break
-				}
-				f = nil
+	var f fs.File
+	var fi fs.FileInfo
+	var layer *unionLayerFS
+
+	if r.URL.Path[len(r.URL.Path)-1] == '/' {
+		if !fsv.EnableIndex {
+			fsv.openFailed(w, r, fsys, fs.ErrNotExist)
+			return
+		}
+
+		for _, indexFileName := range fsv.indexNames() {
+			f, layer, err = openLayered(fsys, path.Join(name, indexFileName))
+			if err == nil {
+				name = path.Join(name, indexFileName)
+				break
 			}
-			if f == nil {
-				NotFound(w, r)
-				return
+			f = nil
+		}
+		if f == nil {
+			if fsv.AutoIndex {
+				if fi, err := fs.Stat(fsys, name); err == nil && fi.IsDir() {
+					fsv.serveDirListing(w, r, fsys, name)
+					return
+				}
 			}
-			defer f.Close()
+			fsv.openFailed(w, r, fsys, err)
+			return
+		}
+		defer f.Close()
 
-			fi, err = f.Stat()
-			if err != nil {
-				NotFound(w, r)
-				return
-			}
-		} else {
-			f, err = os.Open(pth)
-			if err != nil {
-				NotFound(w, r)
+		fi, err = f.Stat()
+		if err != nil {
+			Error(w, r, errorStatus(err))
+			return
+		}
+	} else {
+		if fsv.cacheable(fsys, r, name) {
+			if cf := fsv.Cache.get(fsys, fileCacheKey{fsv, rfs.dir, name}); cf != nil {
+				fsv.serveCached(w, r, name, cf)
 				return
 			}
-			defer f.Close()
+		}
 
-			fi, err = f.Stat()
-			if err != nil {
-				NotFound(w, r)
-				return
+		f, layer, err = openLayered(fsys, name)
+		if err != nil && fsv.CleanURLs && path.Ext(name) == "" && errors.Is(err, fs.ErrNotExist) {
+			var htmlErr error
+			f, layer, htmlErr = openLayered(fsys, name+".html")
+			if htmlErr == nil {
+				name += ".html"
+				err = nil
 			}
+		}
+		if err != nil {
+			fsv.openFailed(w, r, fsys, err)
+			return
+		}
+		defer f.Close()
 
-			if fi.IsDir() {
-				if !enableIndex {
-					NotFound(w, r)
-					return
-				}
-				q := r.URL.Query()
-				if q.Has("*") {
-					q.Del("*")
-					r.URL.RawQuery = q.Encode()
-				}
-				r.URL.Path += "/"
-				Redirect(w, r.URL.String(), http.StatusTemporaryRedirect)
+		fi, err = f.Stat()
+		if err != nil {
+			Error(w, r, errorStatus(err))
+			return
+		}
+
+		if fi.IsDir() {
+			if !fsv.EnableIndex {
+				Error(w, r, http.StatusNotFound)
 				return
 			}
+			u := url.URL{Path: strings.TrimSuffix(fsURLPath(name), "/") + "/", RawQuery: r.URL.RawQuery}
+			if q := r.URL.Query(); q.Has("*") {
+				q.Del("*")
+				u.RawQuery = q.Encode()
+			}
+			Redirect(w, u.String(), http.StatusTemporaryRedirect)
+			return
 		}
 
-		responseFile(w, r, f, fi, cacheAge, responseName)
+		if fsv.CleanURLs && fsv.RedirectHTML && strings.HasSuffix(r.URL.Path, ".html") && fsv.redirectClean(w, r, fsys, name) {
+			return
+		}
+	}
+
+	if len(fsv.SPAFallback) > 0 && name == fsName(fsv.SPAFallback) {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	if layer != nil && len(fsv.LayerHeader) > 0 {
+		w.Header().Set(fsv.LayerHeader, layer.name)
+	}
+
+	if fsv.EarlyHints {
+		fsv.sendEarlyHints(w, r, fsys, name, fi)
+	}
+
+	if fsv.Markdown && isMarkdown(name) && !r.URL.Query().Has("raw") {
+		fsv.serveMarkdown(w, r, name, f, fi)
+		return
+	}
+
+	if fsv.SSI && isSSI(name) {
+		fsv.serveSSI(w, r, fsys, name, f, fi)
+		return
+	}
+
+	var ef *encodedFile
+	if efs, ok := fsys.(encodedFS); ok {
+		addVary(w.Header(), "Accept-Encoding")
+		ef = efs.openEncoded(r, name)
+	} else if fsv.Precompressed {
+		ef = openPrecompressed(layer.source(fsys), r, name)
 	}
+	if ef != nil {
+		defer ef.Close()
+	} else if fsv.cacheable(fsys, r, name) {
+		key := fileCacheKey{fsv, rfs.dir, name}
+		cf := fsv.Cache.get(fsys, key)
+		if cf == nil || !sameFileInfo(cf.fi, fi) {
+			cf = fsv.Cache.put(fsys, key, rfs.osPath(name), f, fi)
+		}
+		if cf != nil {
+			fsv.serveCached(w, r, name, cf)
+			return
+		}
+	}
+
+	fsv.serveFile(w, r, name, f, fi, ef)
 }
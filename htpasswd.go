@@ -0,0 +1,203 @@
+package gotor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Htpasswd struct {
+	File     string
+	Realm    string
+	Prefixes []string
+
+	users    map[string]string
+	verified map[string][32]byte
+	modTime  time.Time
+	size     int64
+	checked  time.Time
+	mtx      sync.Mutex
+}
+
+const apr1Magic = "$apr1$"
+const apr1Itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func apr1Crypt(pass string, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	h := md5.New()
+	h.Write([]byte(pass + apr1Magic + salt))
+	alt := md5.Sum([]byte(pass + salt + pass))
+	for i := len(pass); i > 0; i -= 16 {
+		h.Write(alt[:min(i, 16)])
+	}
+	for i := len(pass); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{pass[0]})
+		}
+	}
+	final := h.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write([]byte(pass))
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write([]byte(pass))
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write([]byte(pass))
+		}
+		final = h.Sum(nil)
+	}
+
+	out := make([]byte, 0, 22)
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out = append(out, apr1Itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	to64(uint32(final[0])<<16|uint32(final[6])<<8|uint32(final[12]), 4)
+	to64(uint32(final[1])<<16|uint32(final[7])<<8|uint32(final[13]), 4)
+	to64(uint32(final[2])<<16|uint32(final[8])<<8|uint32(final[14]), 4)
+	to64(uint32(final[3])<<16|uint32(final[9])<<8|uint32(final[15]), 4)
+	to64(uint32(final[4])<<16|uint32(final[10])<<8|uint32(final[5]), 4)
+	to64(uint32(final[11]), 2)
+	return apr1Magic + salt + "$" + string(out)
+}
+
+func checkHtpasswdHash(hash string, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, apr1Magic):
+		salt, _, ok := strings.Cut(hash[len(apr1Magic):], "$")
+		if !ok {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1Crypt(pass, salt))) == 1
+	}
+	return false
+}
+
+func (hp *Htpasswd) reload() error {
+	now := time.Now()
+	if hp.users != nil && now.Sub(hp.checked) < time.Second {
+		return nil
+	}
+	hp.checked = now
+	fi, err := os.Stat(hp.File)
+	if err != nil {
+		return err
+	}
+	if hp.users != nil && fi.ModTime().Equal(hp.modTime) && fi.Size() == hp.size {
+		return nil
+	}
+	b, err := os.ReadFile(hp.File)
+	if err != nil {
+		return err
+	}
+	users := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if user, hash, ok := strings.Cut(line, ":"); ok {
+			users[user] = hash
+		}
+	}
+	hp.users, hp.verified = users, map[string][32]byte{}
+	hp.modTime, hp.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+func (hp *Htpasswd) Check(user string, pass string) bool {
+	hp.mtx.Lock()
+	if err := hp.reload(); err != nil && hp.users == nil {
+		hp.mtx.Unlock()
+		return false
+	}
+	hash, ok := hp.users[user]
+	verified, isVerified := hp.verified[user]
+	hp.mtx.Unlock()
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(hash + "\x00" + pass))
+	if isVerified && subtle.ConstantTimeCompare(verified[:], sum[:]) == 1 {
+		return true
+	}
+	if !checkHtpasswdHash(hash, pass) {
+		return false
+	}
+	hp.mtx.Lock()
+	if hp.users[user] == hash {
+		hp.verified[user] = sum
+	}
+	hp.mtx.Unlock()
+	return true
+}
+
+func (hp *Htpasswd) protects(urlPath string) bool {
+	if len(hp.Prefixes) == 0 {
+		return true
+	}
+	urlPath = path.Clean("/" + urlPath)
+	for _, p := range hp.Prefixes {
+		if urlPath == strings.TrimSuffix(p, "/") || strings.HasPrefix(urlPath, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (hp *Htpasswd) Wrap(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hp.protects(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if u := GetUser(r); u != nil {
+			if pass, ok := u.Password(); ok && hp.Check(u.Username(), pass) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		realm := hp.Realm
+		if len(realm) == 0 {
+			realm = "Restricted"
+		}
+		w.Header().Set("WWW-Authenticate", "Basic realm=\""+strings.ReplaceAll(realm, "\"", "")+"\", charset=\"UTF-8\"")
+		w.Header().Set("Cache-Control", "no-store")
+		Error(w, r, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,105 @@
+package gotor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestApr1Crypt(t *testing.T) {
+	tests := []struct {
+		pass string
+		hash string
+	}{
+		// openssl passwd -apr1 -salt <salt> <pass>
+		{"myPa$$w0rd", "$apr1$r31.KMq5$w/RlYIYRsGtONUU15xY.a."},
+		{"a", "$apr1$xxxxxxxx$YMs3Jkp9Zx7lSxpQ9nJyH1"},
+		{"a much longer password than sixteen", "$apr1$ab/cd.Ef$FHA0aHw144s2p0tXrf.p61"},
+	}
+	for _, tt := range tests {
+		if !checkHtpasswdHash(tt.hash, tt.pass) {
+			t.Errorf("%q does not match %s", tt.pass, tt.hash)
+		}
+		if checkHtpasswdHash(tt.hash, tt.pass+"x") {
+			t.Errorf("%q matches %s", tt.pass+"x", tt.hash)
+		}
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	bc, err := bcrypt.GenerateFromPassword([]byte("bpass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# comment\n" +
+		"apr:$apr1$r31.KMq5$w/RlYIYRsGtONUU15xY.a.\n" +
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+		"bc:" + string(bc) + "\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hp := &Htpasswd{File: file, Prefixes: []string{"/private"}}
+	h := hp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	tests := []struct {
+		target string
+		user   string
+		pass   string
+		status int
+	}{
+		{"/public", "", "", 200},
+		{"/privateX", "", "", 200},
+		{"/privateX/a", "", "", 200},
+		{"/private", "", "", 401},
+		{"/private/", "", "", 401},
+		{"/private/a", "", "", 401},
+		{"/x/../private/a", "", "", 401},
+		{"/private/a", "apr", "myPa$$w0rd", 200},
+		{"/private/a", "apr", "wrong", 401},
+		{"/private/a", "sha", "secret", 200},
+		{"/private/a", "sha", "Secret", 401},
+		{"/private/a", "bc", "bpass", 200},
+		{"/private/a", "bc", "bpass ", 401},
+		{"/private/a", "nobody", "secret", 401},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if len(tt.user) > 0 {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s:%s: status %d, want %d", tt.target, tt.user, tt.pass, rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusUnauthorized && len(rec.Header().Get("WWW-Authenticate")) == 0 {
+				t.Errorf("%s: missing WWW-Authenticate", tt.target)
+			}
+		}
+	}
+
+	if err := os.WriteFile(file, []byte("sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nnew:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !hp.Check("apr", "myPa$$w0rd") {
+		t.Error("reloaded before the check interval elapsed")
+	}
+	hp.mtx.Lock()
+	hp.checked = time.Time{}
+	hp.mtx.Unlock()
+	if hp.Check("apr", "myPa$$w0rd") {
+		t.Error("removed user still accepted after reload")
+	}
+	if !hp.Check("new", "secret") || !hp.Check("sha", "secret") {
+		t.Error("users from the reloaded file rejected")
+	}
+}
@@ -0,0 +1,402 @@
+package gotor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const maxImagePixels = 64 << 20
+
+var imageRenderSem = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+type ImageSize struct {
+	Width  int
+	Height int
+}
+
+type ImageResizer struct {
+	FileServer *FileServer
+	CacheDir   string
+	Sizes      []ImageSize
+	Qualities  []int
+}
+
+type imageParams struct {
+	width   int
+	height  int
+	fit     string
+	quality int
+}
+
+var imageExts = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+}
+
+var imageFormatExts = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+func (ir *ImageResizer) parseParams(r *http.Request) (*imageParams, bool) {
+	q := r.URL.Query()
+	if !q.Has("w") && !q.Has("h") {
+		return nil, true
+	}
+	ip := &imageParams{fit: strings.ToLower(q.Get("fit")), quality: 85}
+	var err error
+	if q.Has("w") {
+		if ip.width, err = strconv.Atoi(q.Get("w")); err != nil || ip.width <= 0 {
+			return nil, false
+		}
+	}
+	if q.Has("h") {
+		if ip.height, err = strconv.Atoi(q.Get("h")); err != nil || ip.height <= 0 {
+			return nil, false
+		}
+	}
+	switch ip.fit {
+	case "":
+		ip.fit = "contain"
+	case "contain", "cover", "fill":
+	default:
+		return nil, false
+	}
+	if (ip.fit == "cover" || ip.fit == "fill") && (ip.width == 0 || ip.height == 0) {
+		return nil, false
+	}
+	allowed := false
+	for _, sz := range ir.Sizes {
+		if sz.Width == ip.width && sz.Height == ip.height {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, false
+	}
+	if q.Has("q") {
+		if ip.quality, err = strconv.Atoi(q.Get("q")); err != nil {
+			return nil, false
+		}
+		allowed = false
+		for _, iq := range ir.Qualities {
+			if iq == ip.quality {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, false
+		}
+	}
+	return ip, true
+}
+
+func imageFormat(r *http.Request, srcFormat string, opaque bool) string {
+	prefs := []string{"png", "gif", "jpeg"}
+	if opaque {
+		prefs = []string{"jpeg", "png", "gif"}
+	}
+	best, bestQ := srcFormat, 0.0
+	if mediaQuality(r, "image/"+srcFormat) > 0 {
+		bestQ = mediaQuality(r, "image/"+srcFormat)
+	}
+	for _, f := range prefs {
+		if q := mediaQuality(r, "image/"+f); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+type boxWeight struct {
+	ix int
+	w  float64
+}
+
+func boxWeights(srcN int, dstN int) [][]boxWeight {
+	scale := float64(srcN) / float64(dstN)
+	ws := make([][]boxWeight, dstN)
+	for i := range ws {
+		lo := float64(i) * scale
+		hi := lo + scale
+		sum := 0.0
+		for j := int(lo); float64(j) < hi && j < srcN; j++ {
+			w := min(hi, float64(j+1)) - max(lo, float64(j))
+			if w > 0 {
+				ws[i] = append(ws[i], boxWeight{j, w})
+				sum += w
+			}
+		}
+		for k := range ws[i] {
+			ws[i][k].w /= sum
+		}
+	}
+	return ws
+}
+
+func resampleImage(src *image.RGBA, dw int, dh int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	xws, yws := boxWeights(sw, dw), boxWeights(sh, dh)
+
+	tmp := make([]float64, dw*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range xws {
+			var c [4]float64
+			for _, bw := range ws {
+				p := row[bw.ix*4:]
+				c[0] += float64(p[0]) * bw.w
+				c[1] += float64(p[1]) * bw.w
+				c[2] += float64(p[2]) * bw.w
+				c[3] += float64(p[3]) * bw.w
+			}
+			copy(tmp[(y*dw+x)*4:], c[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y, ws := range yws {
+		for x := 0; x < dw; x++ {
+			var c [4]float64
+			for _, bw := range ws {
+				p := tmp[(bw.ix*dw+x)*4:]
+				c[0] += p[0] * bw.w
+				c[1] += p[1] * bw.w
+				c[2] += p[2] * bw.w
+				c[3] += p[3] * bw.w
+			}
+			o := dst.Pix[y*dst.Stride+x*4:]
+			for i := range 4 {
+				o[i] = uint8(min(max(c[i]+0.5, 0), 255))
+			}
+		}
+	}
+	return dst
+}
+
+func resizeImage(img image.Image, ip *imageParams) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	crop := b
+	dw, dh := ip.width, ip.height
+
+	switch ip.fit {
+	case "contain":
+		scale := 1.0
+		if dw > 0 {
+			scale = min(scale, float64(dw)/float64(sw))
+		}
+		if dh > 0 {
+			scale = min(scale, float64(dh)/float64(sh))
+		}
+		dw, dh = max(int(float64(sw)*scale+0.5), 1), max(int(float64(sh)*scale+0.5), 1)
+	case "cover":
+		if sw*dh > sh*dw {
+			cw := sh * dw / dh
+			crop = image.Rect(b.Min.X+(sw-cw)/2, b.Min.Y, b.Min.X+(sw-cw)/2+cw, b.Max.Y)
+		} else {
+			ch := sw * dh / dw
+			crop = image.Rect(b.Min.X, b.Min.Y+(sh-ch)/2, b.Max.X, b.Min.Y+(sh-ch)/2+ch)
+		}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(src, src.Bounds(), img, crop.Min, draw.Src)
+	if src.Bounds().Dx() == dw && src.Bounds().Dy() == dh {
+		return src
+	}
+	return resampleImage(src, dw, dh)
+}
+
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	if !isOpaque(img) {
+		bg := image.NewRGBA(img.Bounds())
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(bg, bg.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = bg
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+func (ir *ImageResizer) cachePath(name string, modTime int64, size int64, ip *imageParams, format string) string {
+	h := sha256.New()
+	io.WriteString(h, strings.Join([]string{
+		name,
+		strconv.FormatInt(modTime, 10),
+		strconv.FormatInt(size, 10),
+		strconv.Itoa(ip.width),
+		strconv.Itoa(ip.height),
+		ip.fit,
+		strconv.Itoa(ip.quality),
+		format,
+	}, "\x00"))
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(ir.CacheDir, key[:2], key+imageFormatExts[format])
+}
+
+func renderImage(src io.Reader, cp string, ip *imageParams, format string) int {
+	if _, err := os.Stat(cp); err == nil {
+		return 0
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return http.StatusUnprocessableEntity
+	}
+	if err := os.MkdirAll(filepath.Dir(cp), 0755); err != nil {
+		return http.StatusInternalServerError
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cp), ".resize-*")
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	err = encodeImage(tmp, resizeImage(img, ip), format, ip.quality)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cp)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return http.StatusInternalServerError
+	}
+	return 0
+}
+
+func (ir *ImageResizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fsv := ir.FileServer
+	srcFormat, isImage := imageExts[strings.ToLower(path.Ext(r.URL.Path))]
+	if !isImage || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		fsv.ServeHTTP(w, r)
+		return
+	}
+	ip, ok := ir.parseParams(r)
+	if !ok {
+		Error(w, r, http.StatusBadRequest)
+		return
+	}
+	if ip == nil {
+		fsv.ServeHTTP(w, r)
+		return
+	}
+
+	fsys, err := fsv.fsys()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	name := fsName(r.URL.Path)
+	if fsv.HideDotfiles && isDotfile(name) {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		Error(w, r, errorStatus(err))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width*cfg.Height > maxImagePixels {
+		Error(w, r, http.StatusUnprocessableEntity)
+		return
+	}
+	opaque := false
+	if m, ok := cfg.ColorModel.(color.Palette); ok {
+		opaque = true
+		for _, c := range m {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				opaque = false
+				break
+			}
+		}
+	} else {
+		switch cfg.ColorModel {
+		case color.YCbCrModel, color.CMYKModel, color.GrayModel, color.Gray16Model:
+			opaque = true
+		}
+	}
+	format := imageFormat(r, srcFormat, opaque)
+	addVary(w.Header(), "Accept")
+
+	cp := ir.cachePath(name, fi.ModTime().UnixNano(), fi.Size(), ip, format)
+	cf, err := os.Open(cp)
+	if err != nil {
+		rs, ok := f.(io.Seeker)
+		if !ok {
+			if f, err = fsys.Open(name); err != nil {
+				Error(w, r, errorStatus(err))
+				return
+			}
+			defer f.Close()
+		} else if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+		select {
+		case imageRenderSem <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		code := renderImage(f, cp, ip, format)
+		<-imageRenderSem
+		if code != 0 {
+			Error(w, r, code)
+			return
+		}
+		if cf, err = os.Open(cp); err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	defer cf.Close()
+	cfi, err := cf.Stat()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	base := strings.TrimSuffix(fi.Name(), path.Ext(fi.Name()))
+	rfi := &renderedFileInfo{cfi, base + imageFormatExts[format], cfi.Size(), fi.ModTime()}
+	w.Header().Set("ETag", "\""+strings.TrimSuffix(filepath.Base(cp), filepath.Ext(cp))[:32]+"\"")
+	fsv.serveFile(w, r, name, cf, rfi, nil)
+}
@@ -0,0 +1,203 @@
+package gotor
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+type MarkdownHeading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+type MarkdownPage struct {
+	Path    string
+	Title   string
+	Meta    map[string]string
+	TOC     []MarkdownHeading
+	Content template.HTML
+}
+
+var DefaultMarkdownTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:sans-serif;margin:2em auto;max-width:50em;padding:0 1em;line-height:1.5}
+pre{overflow:auto;background:#f6f8fa;padding:1em}
+code{background:#f6f8fa}
+table{border-collapse:collapse}
+th,td{border:1px solid #ddd;padding:.25em .5em}
+a.anchor{margin-left:.25em;text-decoration:none;visibility:hidden}
+:hover>a.anchor{visibility:visible}
+nav.toc ul{list-style:none;padding-left:0}
+nav.toc li.h3{padding-left:1em}
+nav.toc li.h4,nav.toc li.h5,nav.toc li.h6{padding-left:2em}
+</style>
+</head>
+<body>
+{{if gt (len .TOC) 1}}<nav class="toc">
+<ul>
+{{range .TOC}}{{if and (ge .Level 2) (le .Level 4)}}<li class="h{{.Level}}"><a href="#{{.ID}}">{{.Text}}</a></li>
+{{end}}{{end}}</ul>
+</nav>
+{{end}}<article>
+{{.Content}}</article>
+</body>
+</html>
+`))
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+type markdownCacheEntry struct {
+	modTime time.Time
+	size    int64
+	out     []byte
+}
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+func splitFrontMatter(src []byte) (map[string]string, []byte) {
+	meta := map[string]string{}
+	rest, ok := bytes.CutPrefix(src, []byte("---\n"))
+	if !ok {
+		if rest, ok = bytes.CutPrefix(src, []byte("---\r\n")); !ok {
+			return meta, src
+		}
+	}
+	for len(rest) > 0 {
+		line, next, _ := bytes.Cut(rest, []byte("\n"))
+		rest = next
+		l := strings.TrimSpace(string(line))
+		if l == "---" {
+			return meta, rest
+		}
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) > 1 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		meta[strings.ToLower(strings.TrimSpace(k))] = v
+	}
+	return map[string]string{}, src
+}
+
+func markdownText(n ast.Node, src []byte, b *strings.Builder) {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch t := c.(type) {
+		case *ast.Text:
+			b.Write(t.Segment.Value(src))
+		case *ast.String:
+			b.Write(t.Value)
+		default:
+			markdownText(c, src, b)
+		}
+	}
+}
+
+func renderMarkdown(src []byte, page *MarkdownPage) error {
+	page.Meta, src = splitFrontMatter(src)
+	page.Title = page.Meta["title"]
+
+	doc := markdown.Parser().Parse(text.NewReader(src))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		var b strings.Builder
+		markdownText(h, src, &b)
+		id := ""
+		if v, ok := h.AttributeString("id"); ok {
+			if bid, ok := v.([]byte); ok {
+				id = string(bid)
+			}
+		}
+		if len(page.Title) == 0 && h.Level == 1 {
+			page.Title = b.String()
+		}
+		page.TOC = append(page.TOC, MarkdownHeading{h.Level, id, b.String()})
+		if len(id) > 0 {
+			a := ast.NewLink()
+			a.Destination = []byte("#" + id)
+			a.SetAttributeString("class", []byte("anchor"))
+			a.AppendChild(a, ast.NewString([]byte("#")))
+			h.AppendChild(h, a)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, src, doc); err != nil {
+		return err
+	}
+	page.Content = template.HTML(buf.String())
+	return nil
+}
+
+func (fsv *FileServer) serveMarkdown(w http.ResponseWriter, r *http.Request, name string, f fs.File, fi fs.FileInfo) {
+	var out []byte
+	if v, ok := fsv.mdCache.Load(name); ok {
+		ce := v.(*markdownCacheEntry)
+		if ce.modTime.Equal(fi.ModTime()) && ce.size == fi.Size() {
+			out = ce.out
+		}
+	}
+	if out == nil {
+		src, err := io.ReadAll(f)
+		if err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+		page := &MarkdownPage{Path: "/" + name}
+		if err := renderMarkdown(src, page); err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+		if len(page.Title) == 0 {
+			page.Title = strings.TrimSuffix(fi.Name(), path.Ext(fi.Name()))
+		}
+		tmpl := fsv.MarkdownTemplate
+		if tmpl == nil {
+			tmpl = DefaultMarkdownTemplate
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, page); err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+		out = buf.Bytes()
+		if !isZeroTime(fi.ModTime()) {
+			fsv.mdCache.Store(name, &markdownCacheEntry{fi.ModTime(), fi.Size(), out})
+		}
+	}
+
+	fsv.serveRendered(w, r, name+".html", out, fi, fi.ModTime())
+}
@@ -0,0 +1,50 @@
+package gotor
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func mediaQuality(r *http.Request, typ string) float64 {
+	q := -1.0
+	specificity := -1
+	major, _, _ := strings.Cut(typ, "/")
+	for _, v := range r.Header.Values("Accept") {
+		for _, item := range strings.Split(v, ",") {
+			params := strings.Split(item, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			var spec int
+			switch name {
+			case typ:
+				spec = 2
+			case major + "/*":
+				spec = 1
+			case "*/*":
+				spec = 0
+			default:
+				continue
+			}
+			if spec <= specificity {
+				continue
+			}
+			iq := 1.0
+			for _, param := range params[1:] {
+				if qs, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					var err error
+					iq, err = strconv.ParseFloat(strings.TrimSpace(qs), 64)
+					if err != nil {
+						iq = 0
+					}
+				}
+			}
+			q, specificity = iq, spec
+		}
+	}
+	return q
+}
+
+func prefersJSON(r *http.Request) bool {
+	jq := mediaQuality(r, "application/json")
+	return jq > 0 && jq > mediaQuality(r, "text/html")
+}
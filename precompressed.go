@@ -0,0 +1,65 @@
+package gotor
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+type encodedFile struct {
+	encoding string
+	ext      string
+	f        fs.File
+	fi       fs.FileInfo
+}
+
+func (ef *encodedFile) Close() error {
+	return ef.f.Close()
+}
+
+var precompressedExts = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+func openPrecompressed(fsys fs.FS, r *http.Request, name string) *encodedFile {
+	var ef *encodedFile
+	bestQ := 0.0
+	for _, pe := range precompressedExts {
+		q := encodingQuality(r, pe.encoding)
+		if q <= bestQ {
+			continue
+		}
+		f, err := fsys.Open(name + pe.ext)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			f.Close()
+			continue
+		}
+		if ef != nil {
+			ef.Close()
+		}
+		ef = &encodedFile{pe.encoding, pe.ext, f, fi}
+		bestQ = q
+	}
+	return ef
+}
+
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
@@ -17,42 +17,6 @@ import (
 	"github.com/yulon/gocks5"
 )
 
-const halfUint64 uint64 = uint64(18446744073709551615) / uint64(2)
-
-type slowReader struct {
-	r      io.Reader
-	spdLmt uint64
-	rn     uint64
-	rnt    time.Time
-}
-
-func newSlowReader(r io.Reader, speedLimit uint64) io.Reader {
-	if speedLimit <= 0 {
-		return r
-	}
-	return &slowReader{r: r, spdLmt: speedLimit}
-}
-
-func (lr slowReader) Read(p []byte) (int, error) {
-	if lr.rn == 0 {
-		lr.rnt = time.Now()
-	}
-	n, err := lr.Read(p)
-	if n <= 0 {
-		return n, err
-	}
-	lr.rn += uint64(n)
-	s := time.Now().Sub(lr.rnt).Seconds()
-	if s >= 1 || lr.rn > halfUint64 {
-		exceedN := lr.rn - uint64(s*float64(lr.spdLmt))
-		if exceedN > 0 {
-			time.Sleep(time.Duration(exceedN*uint64(1000)/lr.spdLmt) * time.Millisecond)
-		}
-		lr.rn = 0
-	}
-	return n, err
-}
-
 func hostToAddr(proto, host string) (addr string) {
 	addr = host
 	colonPos := strings.LastIndexByte(addr, ':')
@@ -89,11 +53,11 @@ func basicAuthDec(s string) *url.Userinfo {
 	if len(auth) == 0 {
 		return nil
 	}
-	parts := strings.SplitN(string(b), ":", 2)
-	if len(parts) != 2 {
-		return url.User(strings.TrimSpace(parts[0]))
+	user, pass, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return url.User(strings.TrimSpace(user))
 	}
-	return url.UserPassword(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return url.UserPassword(strings.TrimSpace(user), pass)
 }
 
 var otherAuthDecs = map[string]func(string) *url.Userinfo{}
@@ -199,7 +163,7 @@ func getUser(r *http.Request, field string) *url.Userinfo {
 	pat := strings.TrimSpace(paParts[0])
 	pac := strings.TrimSpace(paParts[1])
 
-	if pat == "Basic" {
+	if strings.EqualFold(pat, "Basic") {
 		return basicAuthDec(pac)
 	}
 	pacDec, ok := otherAuthDecs[pat]
@@ -213,6 +177,10 @@ func GetProxyUser(r *http.Request) *url.Userinfo {
 	return getUser(r, "Proxy-Authorization")
 }
 
+func GetUser(r *http.Request) *url.Userinfo {
+	return getUser(r, "Authorization")
+}
+
 var proxyDefaultTransport = &http.Transport{
 	ForceAttemptHTTP2:     true,
 	MaxIdleConns:          100,
@@ -238,7 +206,7 @@ func (pxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusProxyAuthRequired)
 				return
 			}
-			w.WriteHeader(http.StatusForbidden)
+			Error(w, r, http.StatusForbidden)
 			return
 		}
 		if pcc.Transport != nil {
@@ -254,7 +222,7 @@ func (pxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			var proxy *url.URL
 			proxy, err = tp.Proxy(r)
 			if err != nil {
-				w.WriteHeader(http.StatusBadGateway)
+				Error(w, r, http.StatusBadGateway)
 				return
 			}
 			if proxy != nil {
@@ -278,14 +246,14 @@ func (pxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			svrCon, err = net.Dial("tcp", svrAddr)
 		}
 		if err != nil {
-			w.WriteHeader(http.StatusBadGateway)
+			Error(w, r, http.StatusBadGateway)
 			return
 		}
 
 		cltCon, cltBuf, err := w.(http.Hijacker).Hijack()
 		if err != nil {
 			svrCon.Close()
-			w.WriteHeader(http.StatusBadGateway)
+			Error(w, r, http.StatusBadGateway)
 			return
 		}
 
@@ -329,7 +297,7 @@ func (pxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := tp.RoundTrip(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		Error(w, r, http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
@@ -369,6 +337,9 @@ func ReverseProxy(target string, fixHost, fixRedirect bool) http.HandlerFunc {
 		return nil
 	}
 	shrp := httputil.NewSingleHostReverseProxy(tu)
+	shrp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		Error(w, r, http.StatusBadGateway)
+	}
 	if fixHost {
 		if fixRedirect {
 			return func(w http.ResponseWriter, r *http.Request) {
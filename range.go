@@ -0,0 +1,161 @@
+package gotor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type httpRange struct {
+	start, length int64
+}
+
+func (hr httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", hr.start, hr.start+hr.length-1, size)
+}
+
+func (hr httpRange) mimeHeader(contType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {hr.contentRange(size)},
+		"Content-Type":  {contType},
+	}
+}
+
+var errInvalidRange = errors.New("invalid range")
+var errNoOverlap = errors.New("invalid range: failed to overlap")
+
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+		var hr httpRange
+		if start == "" {
+			if end == "" || end[0] == '-' {
+				return nil, errInvalidRange
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errInvalidRange
+			}
+			if i == 0 {
+				noOverlap = true
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			hr.start = size - i
+			hr.length = size - hr.start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			hr.start = i
+			if end == "" {
+				hr.length = size - hr.start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || hr.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				hr.length = i - hr.start + 1
+			}
+		}
+		ranges = append(ranges, hr)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func multipartRangesSize(ranges []httpRange, boundary string, contType string, size int64) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		mw.CreatePart(ra.mimeHeader(contType, size))
+		cw += countingWriter(ra.length)
+	}
+	mw.Close()
+	return int64(cw)
+}
+
+func responseRanges(w http.ResponseWriter, r *http.Request, f io.ReadSeeker, ranges []httpRange, contType string, size int64) {
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		w.Header().Set("Content-Range", ra.contentRange(size))
+		w.Header().Set("Content-Type", contType)
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return
+		}
+		if _, err := f.Seek(ra.start, io.SeekStart); err != nil {
+			return
+		}
+		io.CopyN(w, f, ra.length)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(multipartRangesSize(ranges, mw.Boundary(), contType, size), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return
+	}
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.mimeHeader(contType, size))
+		if err != nil {
+			return
+		}
+		if _, err := f.Seek(ra.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err := io.CopyN(part, f, ra.length); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
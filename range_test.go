@@ -0,0 +1,91 @@
+package gotor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges []httpRange
+		err    error
+	}{
+		{"bytes=0-4", []httpRange{{0, 5}}, nil},
+		{"bytes=5-", []httpRange{{5, 11}}, nil},
+		{"bytes=-3", []httpRange{{13, 3}}, nil},
+		{"bytes=-16", []httpRange{{0, 16}}, nil},
+		{"bytes=-100", []httpRange{{0, 16}}, nil},
+		{"bytes=10-100", []httpRange{{10, 6}}, nil},
+		{"bytes=0-5, 3-8", []httpRange{{0, 6}, {3, 6}}, nil},
+		{"bytes=0-1,,-2", []httpRange{{0, 2}, {14, 2}}, nil},
+		{"bytes=100-,2-3", []httpRange{{2, 2}}, nil},
+		{"bytes=16-", nil, errNoOverlap},
+		{"bytes=-0", nil, errNoOverlap},
+		{"bytes=5-2", nil, errInvalidRange},
+		{"bytes=-", nil, errInvalidRange},
+		{"bytes=--1", nil, errInvalidRange},
+		{"bytes=x-1", nil, errInvalidRange},
+		{"bytes=1", nil, errInvalidRange},
+		{"items=0-1", nil, errInvalidRange},
+	}
+	for _, tt := range tests {
+		ranges, err := parseRange(tt.header, 16)
+		if err != tt.err || !reflect.DeepEqual(ranges, tt.ranges) {
+			t.Errorf("%q: got %v, %v; want %v, %v", tt.header, ranges, err, tt.ranges, tt.err)
+		}
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.bin"), []byte("0123456789abcdef"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsv := &FileServer{Root: dir}
+	tests := []struct {
+		header       string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"bytes=2-5", 206, "bytes 2-5/16", "2345"},
+		{"bytes=-3", 206, "bytes 13-15/16", "def"},
+		{"bytes=-100", 206, "bytes 0-15/16", "0123456789abcdef"},
+		{"bytes=10-100", 206, "bytes 10-15/16", "abcdef"},
+		{"bytes=100-", 416, "bytes */16", ""},
+		{"bytes=0-9,5-15", 200, "", "0123456789abcdef"},
+		{"bytes=5-2", 200, "", "0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/a.bin", nil)
+		r.Header.Set("Range", tt.header)
+		rec := httptest.NewRecorder()
+		fsv.ServeHTTP(rec, r)
+		if rec.Code != tt.status || rec.Header().Get("Content-Range") != tt.contentRange {
+			t.Errorf("%q: status %d, Content-Range %q; want %d, %q", tt.header, rec.Code, rec.Header().Get("Content-Range"), tt.status, tt.contentRange)
+			continue
+		}
+		if rec.Code != http.StatusRequestedRangeNotSatisfiable && rec.Body.String() != tt.body {
+			t.Errorf("%q: body %q, want %q", tt.header, rec.Body.String(), tt.body)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/a.bin", nil)
+	r.Header.Set("Range", "bytes=0-5,3-8")
+	rec := httptest.NewRecorder()
+	fsv.ServeHTTP(rec, r)
+	if rec.Code != http.StatusPartialContent || !strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges; ") ||
+		!strings.Contains(rec.Body.String(), "bytes 0-5/16") || !strings.Contains(rec.Body.String(), "bytes 3-8/16") {
+		t.Errorf("overlapping ranges: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("overlapping ranges: Content-Length %s, body %d bytes", cl, rec.Body.Len())
+	}
+}
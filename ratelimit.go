@@ -0,0 +1,202 @@
+package gotor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const throttleChunk = 16 << 10
+
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mtx    sync.Mutex
+}
+
+func NewRateLimiter(bytesPerSec uint64, burst uint64) *RateLimiter {
+	if burst == 0 {
+		burst = bytesPerSec
+	}
+	return &RateLimiter{rate: float64(bytesPerSec), burst: float64(burst), tokens: float64(burst)}
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	if !rl.last.IsZero() {
+		rl.tokens = min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	}
+	rl.last = now
+}
+
+func (rl *RateLimiter) full(now time.Time) bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	rl.refill(now)
+	return rl.tokens >= rl.burst
+}
+
+func (rl *RateLimiter) WaitN(ctx context.Context, n int) error {
+	rl.mtx.Lock()
+	rl.refill(time.Now())
+	rl.tokens -= float64(n)
+	deficit := -rl.tokens
+	rl.mtx.Unlock()
+	if deficit <= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(deficit / rl.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rl *RateLimiter) chunk() int {
+	return max(min(throttleChunk, int(rl.burst)), 1)
+}
+
+type SlowReader struct {
+	r  io.Reader
+	rl *RateLimiter
+}
+
+func NewSlowReader(r io.Reader, speedLimit uint64) io.Reader {
+	if speedLimit == 0 {
+		return r
+	}
+	return &SlowReader{r, NewRateLimiter(speedLimit, 0)}
+}
+
+func (sr *SlowReader) Read(p []byte) (int, error) {
+	if c := sr.rl.chunk(); len(p) > c {
+		p = p[:c]
+	}
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		sr.rl.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
+type ipRateLimiter struct {
+	*RateLimiter
+	refs int
+}
+
+type RateLimit struct {
+	PerResponse uint64
+	PerIP       uint64
+	Global      uint64
+	Burst       uint64
+
+	global    *RateLimiter
+	ips       map[string]*ipRateLimiter
+	lastSweep time.Time
+	mtx       sync.Mutex
+}
+
+func (rlp *RateLimit) acquire(ip string) (*RateLimiter, *RateLimiter) {
+	rlp.mtx.Lock()
+	defer rlp.mtx.Unlock()
+	if rlp.Global > 0 && rlp.global == nil {
+		rlp.global = NewRateLimiter(rlp.Global, rlp.Burst)
+	}
+	if rlp.PerIP == 0 {
+		return rlp.global, nil
+	}
+	if rlp.ips == nil {
+		rlp.ips = map[string]*ipRateLimiter{}
+	}
+	now := time.Now()
+	if now.Sub(rlp.lastSweep) >= time.Minute {
+		rlp.lastSweep = now
+		for k, l := range rlp.ips {
+			if l.refs == 0 && l.full(now) {
+				delete(rlp.ips, k)
+			}
+		}
+	}
+	l, ok := rlp.ips[ip]
+	if !ok {
+		l = &ipRateLimiter{NewRateLimiter(rlp.PerIP, rlp.Burst), 0}
+		rlp.ips[ip] = l
+	}
+	l.refs++
+	return rlp.global, l.RateLimiter
+}
+
+func (rlp *RateLimit) release(ip string) {
+	rlp.mtx.Lock()
+	defer rlp.mtx.Unlock()
+	if l, ok := rlp.ips[ip]; ok {
+		l.refs--
+	}
+}
+
+type throttledRespWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*RateLimiter
+	chunk    int
+}
+
+func (trw *throttledRespWriter) Unwrap() http.ResponseWriter {
+	return trw.ResponseWriter
+}
+
+func (trw *throttledRespWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), trw.chunk)
+		for _, l := range trw.limiters {
+			if err := l.WaitN(trw.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		n, err := trw.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (rlp *RateLimit) throttle(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	ip := remoteIP(r)
+	global, perIP := rlp.acquire(ip)
+	trw := &throttledRespWriter{ResponseWriter: w, ctx: r.Context(), chunk: throttleChunk}
+	if rlp.PerResponse > 0 {
+		trw.limiters = append(trw.limiters, NewRateLimiter(rlp.PerResponse, rlp.Burst))
+	}
+	if perIP != nil {
+		trw.limiters = append(trw.limiters, perIP)
+	}
+	if global != nil {
+		trw.limiters = append(trw.limiters, global)
+	}
+	for _, l := range trw.limiters {
+		trw.chunk = min(trw.chunk, l.chunk())
+	}
+	return trw, func() {
+		if perIP != nil {
+			rlp.release(ip)
+		}
+	}
+}
+
+func (rlp *RateLimit) Wrap(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tw, done := rlp.throttle(w, r)
+		defer done()
+		h.ServeHTTP(tw, r)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/andybalholm/brotli"
@@ -31,7 +32,51 @@ func newSmartRespWriter(srcResp http.ResponseWriter, req *http.Request) *smartRe
 	return &smartRespWriter{srcResp, req, nil, false, -1, false, false}
 }
 
+func encodingQuality(r *http.Request, enc string) float64 {
+	q := -1.0
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != enc && (name != "*" || q >= 0) {
+				continue
+			}
+			iq := 1.0
+			if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				iq, err = strconv.ParseFloat(strings.TrimSpace(qs), 64)
+				if err != nil {
+					iq = 0
+				}
+			}
+			if name == enc {
+				return iq
+			}
+			q = iq
+		}
+	}
+	return q
+}
+
+func acceptsEncoding(r *http.Request, enc string) bool {
+	return encodingQuality(r, enc) > 0
+}
+
+func (srw *smartRespWriter) weakenETag() {
+	etag := srw.Header().Get("ETag")
+	if len(etag) > 0 {
+		srw.Header().Set("ETag", weakETag(etag))
+	}
+}
+
 func (srw *smartRespWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		srw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if srw.isWritten {
+		return
+	}
 	srw.status = status
 }
 
@@ -46,21 +91,28 @@ func (srw *smartRespWriter) Write(data []byte) (int, error) {
 		}
 		if len(srw.Header().Get("Content-Length")) == 0 {
 			ce := strings.ToLower(srw.Header().Get("Content-Encoding"))
+			if ce == "br" || ce == "gzip" {
+				addVary(srw.Header(), "Accept-Encoding")
+			}
 			switch ce {
 			case "br":
-				if strings.Contains(strings.ToLower(srw.req.Header.Get("Accept-Encoding")), "br") {
+				if acceptsEncoding(srw.req, "br") {
 					srw.wantBr = true
+					srw.weakenETag()
 					break
 				}
 				srw.Header().Set("Content-Encoding", "gzip")
 				fallthrough
 			case "gzip":
-				if strings.Contains(strings.ToLower(srw.req.Header.Get("Accept-Encoding")), "gzip") {
+				if acceptsEncoding(srw.req, "gzip") {
 					srw.wantGz = true
+					srw.weakenETag()
 				} else {
 					srw.Header().Del("Content-Encoding")
 					srw.ResponseWriter.WriteHeader(srw.status)
 				}
+			default:
+				srw.ResponseWriter.WriteHeader(srw.status)
 			}
 		} else {
 			srw.ResponseWriter.WriteHeader(srw.status)
@@ -86,6 +138,9 @@ func (srw *smartRespWriter) Write(data []byte) (int, error) {
 
 func (srw *smartRespWriter) Close() error {
 	if !srw.isWritten {
+		if srw.status < 0 {
+			srw.status = http.StatusOK
+		}
 		srw.ResponseWriter.WriteHeader(srw.status)
 		return nil
 	}
@@ -0,0 +1,66 @@
+package gotor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+type SymlinkPolicy int
+
+const (
+	SymlinkWithinRoot SymlinkPolicy = iota
+	SymlinkDeny
+	SymlinkFollowAll
+)
+
+func DirFS(dir string, symlinks SymlinkPolicy) (fs.FS, error) {
+	if symlinks == SymlinkFollowAll {
+		return os.DirFS(dir), nil
+	}
+	if symlinks == SymlinkDeny {
+		return openNoSymlinkFS(dir)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return root.FS(), nil
+}
+
+func checkNoSymlinks(root *os.Root, op string, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '/' {
+			continue
+		}
+		fi, err := root.Lstat(name[:i])
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return nil
+}
+
+func isPathEscape(err error) bool {
+	var pe *fs.PathError
+	return errors.As(err, &pe) && pe.Err.Error() == "path escapes from parent"
+}
+
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if len(name) == 0 {
+		return "."
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+//go:build !unix
+
+package gotor
+
+import (
+	"io/fs"
+	"os"
+)
+
+// Without openat(2) and O_NOFOLLOW each component is checked with Lstat
+// before os.Root opens the path, so a component swapped for a symlink in
+// between is still followed, though never outside the root.
+type noSymlinkFS struct {
+	root *os.Root
+}
+
+func openNoSymlinkFS(dir string) (fs.FS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &noSymlinkFS{root}, nil
+}
+
+func (nsfs *noSymlinkFS) Close() error {
+	return nsfs.root.Close()
+}
+
+func (nsfs *noSymlinkFS) Open(name string) (fs.File, error) {
+	if err := checkNoSymlinks(nsfs.root, "open", name); err != nil {
+		return nil, err
+	}
+	return nsfs.root.Open(name)
+}
+
+func (nsfs *noSymlinkFS) Stat(name string) (fs.FileInfo, error) {
+	if err := checkNoSymlinks(nsfs.root, "stat", name); err != nil {
+		return nil, err
+	}
+	return nsfs.root.Stat(name)
+}
+
+func (nsfs *noSymlinkFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := checkNoSymlinks(nsfs.root, "readdir", name); err != nil {
+		return nil, err
+	}
+	f, err := nsfs.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
@@ -0,0 +1,85 @@
+package gotor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServerSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{root, filepath.Join(root, "sub"), outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(root, "ok.txt"):        "ok",
+		filepath.Join(root, "a..b.txt"):      "dots",
+		filepath.Join(root, "sub", "in.txt"): "in",
+		filepath.Join(outside, "secret.txt"): "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"inlink":  "ok.txt",
+		"abslink": filepath.Join(outside, "secret.txt"),
+		"rellink": filepath.Join("..", "outside", "secret.txt"),
+		"linkdir": "sub",
+		"outdir":  outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skip("symlinks unsupported:", err)
+		}
+	}
+
+	policies := []struct {
+		name   string
+		policy SymlinkPolicy
+	}{
+		{"WithinRoot", SymlinkWithinRoot},
+		{"Deny", SymlinkDeny},
+		{"FollowAll", SymlinkFollowAll},
+	}
+	tests := []struct {
+		target string
+		status [3]int
+		body   string
+	}{
+		{"/ok.txt", [3]int{200, 200, 200}, "ok"},
+		{"/a..b.txt", [3]int{200, 200, 200}, "dots"},
+		{"/sub/in.txt", [3]int{200, 200, 200}, "in"},
+		{"/../outside/secret.txt", [3]int{404, 404, 404}, ""},
+		{"/sub/../../outside/secret.txt", [3]int{404, 404, 404}, ""},
+		{"/%2e%2e/outside/secret.txt", [3]int{404, 404, 404}, ""},
+		{"/sub/%2e%2e%2f%2e%2e%2foutside/secret.txt", [3]int{404, 404, 404}, ""},
+		{"/..%5coutside%5csecret.txt", [3]int{404, 404, 404}, ""},
+		{"/inlink", [3]int{200, 403, 200}, "ok"},
+		{"/abslink", [3]int{403, 403, 200}, "secret"},
+		{"/rellink", [3]int{403, 403, 200}, "secret"},
+		{"/linkdir/in.txt", [3]int{200, 403, 200}, "in"},
+		{"/outdir/secret.txt", [3]int{403, 403, 200}, "secret"},
+		{"/ok.txt/x", [3]int{404, 404, 404}, ""},
+	}
+
+	for i, p := range policies {
+		fsv := &FileServer{Root: root, Symlinks: p.policy}
+		for _, tt := range tests {
+			rec := httptest.NewRecorder()
+			fsv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status[i] {
+				t.Errorf("%s %s: status %d, want %d", p.name, tt.target, rec.Code, tt.status[i])
+				continue
+			}
+			if rec.Code == http.StatusOK && rec.Body.String() != tt.body {
+				t.Errorf("%s %s: body %q, want %q", p.name, tt.target, rec.Body.String(), tt.body)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//go:build unix
+
+package gotor
+
+import (
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+type noSymlinkFS struct {
+	fd      int
+	cleanup runtime.Cleanup
+}
+
+func openNoSymlinkFS(dir string) (fs.FS, error) {
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: err}
+	}
+	nsfs := &noSymlinkFS{fd: fd}
+	nsfs.cleanup = runtime.AddCleanup(nsfs, func(fd int) { unix.Close(fd) }, fd)
+	return nsfs, nil
+}
+
+func (nsfs *noSymlinkFS) Close() error {
+	nsfs.cleanup.Stop()
+	return unix.Close(nsfs.fd)
+}
+
+func openatNoFollow(dirfd int, name string, flags int) (int, error) {
+	for {
+		fd, err := unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW|flags, 0)
+		if err != unix.EINTR {
+			return fd, err
+		}
+	}
+}
+
+func (nsfs *noSymlinkFS) open(op string, name string) (*os.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	defer runtime.KeepAlive(nsfs)
+	parent, rest := nsfs.fd, name
+	for {
+		elem, next, more := strings.Cut(rest, "/")
+		flags := 0
+		if more {
+			flags = unix.O_DIRECTORY
+		}
+		fd, err := openatNoFollow(parent, elem, flags)
+		if err == unix.ENOTDIR && more {
+			var st unix.Stat_t
+			if unix.Fstatat(parent, elem, &st, unix.AT_SYMLINK_NOFOLLOW) == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+				err = unix.ELOOP
+			}
+		}
+		if parent != nsfs.fd {
+			unix.Close(parent)
+		}
+		if err != nil {
+			switch err {
+			case unix.ELOOP, unix.EMLINK:
+				err = fs.ErrPermission
+			case unix.ENOTDIR:
+				err = fs.ErrNotExist
+			}
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if !more {
+			return os.NewFile(uintptr(fd), name), nil
+		}
+		parent, rest = fd, next
+	}
+}
+
+func (nsfs *noSymlinkFS) Open(name string) (fs.File, error) {
+	return nsfs.open("open", name)
+}
+
+func (nsfs *noSymlinkFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := nsfs.open("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (nsfs *noSymlinkFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := nsfs.open("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
@@ -24,7 +24,7 @@ func (m PathRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	NotFound(w, r)
+	Error(w, r, http.StatusNotFound)
 }
 
 func matchRoute(w http.ResponseWriter, r *http.Request, m map[string]http.Handler, key string) {
@@ -38,7 +38,7 @@ func matchRoute(w http.ResponseWriter, r *http.Request, m map[string]http.Handle
 		h.ServeHTTP(w, r)
 		return
 	}
-	NotFound(w, r)
+	Error(w, r, http.StatusNotFound)
 }
 
 type MethodRouter map[string]http.Handler
@@ -62,7 +62,7 @@ type UserAgentRouter map[string]http.Handler
 func (m UserAgentRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	dh, ok := m["*"]
 	if !ok {
-		dh = NotFound
+		dh = ErrorHandler(http.StatusNotFound)
 	}
 	ua := r.UserAgent()
 	for k, h := range m {
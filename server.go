@@ -11,7 +11,9 @@ import (
 	"github.com/caddyserver/certmagic"
 )
 
-var NotFound http.HandlerFunc = http.NotFound
+var NotFound http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+	DefaultError(w, r, http.StatusNotFound)
+}
 
 func SmartHandler(src http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -29,7 +31,7 @@ func ServeHTTP(lnr net.Listener, h http.Handler) error {
 	return http.Serve(lnr, SmartHandler(h))
 }
 
-func NewTLSConfig(email string, domains []string, dnsProvider certmagic.DNSProvider) (*tls.Config, error) {
+func newCertConfig(email string, dnsProvider certmagic.DNSProvider) *certmagic.Config {
 	cfg := certmagic.NewDefault()
 
 	isr := certmagic.NewACMEIssuer(cfg, certmagic.DefaultACME)
@@ -45,6 +47,11 @@ func NewTLSConfig(email string, domains []string, dnsProvider certmagic.DNSProvi
 	}
 
 	cfg.Issuers = append(cfg.Issuers, isr)
+	return cfg
+}
+
+func NewTLSConfig(email string, domains []string, dnsProvider certmagic.DNSProvider) (*tls.Config, error) {
+	cfg := newCertConfig(email, dnsProvider)
 
 	err := cfg.ManageSync(context.Background(), domains)
 	if err != nil {
@@ -57,6 +64,16 @@ func NewTLSConfig(email string, domains []string, dnsProvider certmagic.DNSProvi
 	return tlsConfig, nil
 }
 
+func NewOnDemandTLSConfig(email string, dnsProvider certmagic.DNSProvider, decide func(ctx context.Context, name string) error) *tls.Config {
+	cfg := newCertConfig(email, dnsProvider)
+	cfg.OnDemand = &certmagic.OnDemandConfig{DecisionFunc: decide}
+
+	tlsConfig := cfg.TLSConfig()
+	tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
+
+	return tlsConfig
+}
+
 func listenTLS(addr string, email string, domains []string, dnsProvider certmagic.DNSProvider) (net.Listener, error) {
 	tlsConfig, err := NewTLSConfig(email, domains, dnsProvider)
 	if err != nil {
@@ -108,6 +125,14 @@ func HTTPSWithHTTP(addr string, email string, dnsProvider certmagic.DNSProvider,
 	return http.Serve(lnr, SmartHandler(domainHandlers))
 }
 
+func HTTPSVirtualHosts(addr string, email string, dnsProvider certmagic.DNSProvider, vh *VirtualHosts) error {
+	lnr, err := tls.Listen("tcp", addr, NewOnDemandTLSConfig(email, dnsProvider, vh.AllowCert))
+	if err != nil {
+		return err
+	}
+	return http.Serve(lnr, SmartHandler(vh))
+}
+
 func CGI(h http.HandlerFunc) error {
 	return cgi.Serve(SmartHandler(h))
 }
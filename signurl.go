@@ -0,0 +1,139 @@
+package gotor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type URLSigner struct {
+	Keys [][]byte
+}
+
+var errNoSigningKey = errors.New("no signing key")
+var ErrSignatureInvalid = errors.New("invalid url signature")
+var ErrSignatureExpired = errors.New("url signature expired")
+var ErrMethodNotSigned = errors.New("method not allowed by url signature")
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func signURLPayload(key []byte, path string, q url.Values, clientIP string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(q.Encode()))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(clientIP))
+	return mac.Sum(nil)
+}
+
+func (us *URLSigner) Sign(rawURL string, expires time.Time, clientIP string, methods ...string) (string, error) {
+	if len(us.Keys) == 0 {
+		return "", errNoSigningKey
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Del("sig")
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Del("bind")
+	if len(clientIP) > 0 {
+		q.Set("bind", "ip")
+	}
+	q.Del("methods")
+	if len(methods) > 0 {
+		q.Set("methods", strings.ToUpper(strings.Join(methods, ",")))
+	}
+	path := u.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	sig := signURLPayload(us.Keys[0], path, q, clientIP)
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(sig))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (us *URLSigner) Verify(r *http.Request) error {
+	q := r.URL.Query()
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil || len(sig) == 0 {
+		return ErrSignatureInvalid
+	}
+	q.Del("sig")
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	clientIP := ""
+	if q.Get("bind") == "ip" {
+		clientIP = remoteIP(r)
+	}
+
+	valid := false
+	for _, key := range us.Keys {
+		if hmac.Equal(sig, signURLPayload(key, r.URL.Path, q, clientIP)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrSignatureInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrSignatureExpired
+	}
+
+	for _, m := range signedMethods(q) {
+		if m == "*" || m == r.Method || (m == http.MethodGet && r.Method == http.MethodHead) {
+			return nil
+		}
+	}
+	return ErrMethodNotSigned
+}
+
+func signedMethods(q url.Values) []string {
+	methods := q.Get("methods")
+	if len(methods) == 0 {
+		return []string{http.MethodGet}
+	}
+	return strings.Split(methods, ",")
+}
+
+func (us *URLSigner) Wrap(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := us.Verify(r); err != nil {
+			if err == ErrMethodNotSigned {
+				allow := signedMethods(r.URL.Query())
+				if slices.Contains(allow, http.MethodGet) && !slices.Contains(allow, http.MethodHead) {
+					allow = append(allow, http.MethodHead)
+				}
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				Error(w, r, http.StatusMethodNotAllowed)
+				return
+			}
+			Error(w, r, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		h.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package gotor
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+func isNavigationRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return path.Ext(r.URL.Path) == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (fsv *FileServer) serveSPAFallback(w http.ResponseWriter, r *http.Request, fsys fs.FS) bool {
+	if len(fsv.SPAFallback) == 0 || !isNavigationRequest(r) {
+		return false
+	}
+
+	name := fsName(fsv.SPAFallback)
+	f, layer, err := openLayered(fsys, name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+
+	var ef *encodedFile
+	if fsv.Precompressed {
+		ef = openPrecompressed(layer.source(fsys), r, name)
+		if ef != nil {
+			defer ef.Close()
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	fsv.serveFile(w, r, name, f, fi, ef)
+	return true
+}
+
+func (fsv *FileServer) openFailed(w http.ResponseWriter, r *http.Request, fsys fs.FS, err error) {
+	status := errorStatus(err)
+	if status == http.StatusNotFound && fsv.serveSPAFallback(w, r, fsys) {
+		return
+	}
+	Error(w, r, status)
+}
@@ -0,0 +1,187 @@
+package gotor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxIncludeDepth = 16
+
+var ssiDirective = regexp.MustCompile(`<!--#include\s+(virtual|file)\s*=\s*"([^"]*)"\s*-->`)
+var ssiErrorMsg = []byte("[an error occurred while processing this directive]")
+
+type renderedFileInfo struct {
+	fs.FileInfo
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (rfi *renderedFileInfo) Name() string {
+	return rfi.name
+}
+
+func (rfi *renderedFileInfo) Size() int64 {
+	return rfi.size
+}
+
+func (rfi *renderedFileInfo) ModTime() time.Time {
+	return rfi.modTime
+}
+
+func (fsv *FileServer) serveRendered(w http.ResponseWriter, r *http.Request, name string, out []byte, fi fs.FileInfo, modTime time.Time) {
+	rfi := &renderedFileInfo{fi, strings.TrimSuffix(fi.Name(), path.Ext(fi.Name())) + ".html", int64(len(out)), modTime}
+	fsv.serveFile(w, r, name, &rawFile{io.NewSectionReader(bytes.NewReader(out), 0, rfi.size), rfi}, rfi, nil)
+}
+
+func isSSI(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".shtml", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+type ssiDep struct {
+	name    string
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+type ssiCacheEntry struct {
+	deps []ssiDep
+	out  []byte
+}
+
+func (ce *ssiCacheEntry) valid(fsys fs.FS) bool {
+	for _, d := range ce.deps {
+		fi, err := fs.Stat(fsys, d.name)
+		if d.missing {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return false
+			}
+			continue
+		}
+		if err != nil || isZeroTime(fi.ModTime()) || !fi.ModTime().Equal(d.modTime) || fi.Size() != d.size {
+			return false
+		}
+	}
+	return true
+}
+
+func (ce *ssiCacheEntry) modTime() time.Time {
+	var mt time.Time
+	for _, d := range ce.deps {
+		if d.modTime.After(mt) {
+			mt = d.modTime
+		}
+	}
+	return mt
+}
+
+func (fsv *FileServer) includeTarget(cur string, kind string, target string) (string, bool) {
+	if len(target) == 0 || strings.ContainsRune(target, '\\') {
+		return "", false
+	}
+	if kind == "virtual" && target[0] == '/' {
+		target = fsName(target)
+	} else {
+		if kind == "file" && (target[0] == '/' || strings.Contains("/"+target+"/", "/../")) {
+			return "", false
+		}
+		target = fsName("/" + path.Join(path.Dir(cur), target))
+	}
+	if target == "." || (fsv.HideDotfiles && isDotfile(target)) {
+		return "", false
+	}
+	return target, true
+}
+
+func (fsv *FileServer) expandIncludes(fsys fs.FS, stack []string, src []byte, ce *ssiCacheEntry) []byte {
+	locs := ssiDirective.FindAllSubmatchIndex(src, -1)
+	if len(locs) == 0 {
+		return src
+	}
+	var buf bytes.Buffer
+	last := 0
+	for _, loc := range locs {
+		buf.Write(src[last:loc[0]])
+		last = loc[1]
+		target, ok := fsv.includeTarget(stack[len(stack)-1], string(src[loc[2]:loc[3]]), string(src[loc[4]:loc[5]]))
+		if !ok || len(stack) > maxIncludeDepth || slices.Contains(stack, target) {
+			buf.Write(ssiErrorMsg)
+			continue
+		}
+		inc, err := fsv.readDep(fsys, target, ce)
+		if err != nil {
+			buf.Write(ssiErrorMsg)
+			continue
+		}
+		buf.Write(fsv.expandIncludes(fsys, append(stack, target), inc, ce))
+	}
+	buf.Write(src[last:])
+	return buf.Bytes()
+}
+
+func (fsv *FileServer) readDep(fsys fs.FS, name string, ce *ssiCacheEntry) ([]byte, error) {
+	dep := ssiDep{name: name}
+	defer func() {
+		ce.deps = append(ce.deps, dep)
+	}()
+	f, err := fsys.Open(name)
+	if err != nil {
+		dep.missing = errors.Is(err, fs.ErrNotExist)
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		dep.modTime, dep.size = fi.ModTime(), fi.Size()
+		return nil, fs.ErrInvalid
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	dep.modTime, dep.size = fi.ModTime(), fi.Size()
+	return b, nil
+}
+
+func (fsv *FileServer) serveSSI(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, f fs.File, fi fs.FileInfo) {
+	if v, ok := fsv.ssiCache.Load(name); ok {
+		if ce := v.(*ssiCacheEntry); ce.valid(fsys) {
+			fsv.serveRendered(w, r, name, ce.out, fi, ce.modTime())
+			return
+		}
+	}
+
+	src, err := io.ReadAll(f)
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	ce := &ssiCacheEntry{deps: []ssiDep{{name: name, modTime: fi.ModTime(), size: fi.Size()}}}
+	ce.out = fsv.expandIncludes(fsys, []string{name}, src, ce)
+	cacheable := true
+	for _, d := range ce.deps {
+		if !d.missing && isZeroTime(d.modTime) {
+			cacheable = false
+		}
+	}
+	if cacheable {
+		fsv.ssiCache.Store(name, ce)
+	}
+	fsv.serveRendered(w, r, name, ce.out, fi, ce.modTime())
+}
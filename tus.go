@@ -0,0 +1,469 @@
+package gotor
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+type TusUpload struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Expires  time.Time         `json:"expires,omitzero"`
+}
+
+func (tu *TusUpload) Complete() bool {
+	return tu.Offset == tu.Size
+}
+
+type TusServer struct {
+	Dir        string
+	BasePath   string
+	MaxSize    int64
+	Expiration time.Duration
+	CacheAge   int64
+	OnComplete func(r *http.Request, upload *TusUpload)
+
+	root      *os.Root
+	rootMtx   sync.Mutex
+	locks     sync.Map
+	lastSweep time.Time
+}
+
+var tusChecksums = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+const tusChecksumMismatch = 460
+
+func (ts *TusServer) openRoot() (*os.Root, error) {
+	ts.rootMtx.Lock()
+	defer ts.rootMtx.Unlock()
+	if ts.root == nil {
+		root, err := os.OpenRoot(ts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		ts.root = root
+	}
+	if ts.Expiration > 0 && time.Since(ts.lastSweep) > time.Minute {
+		ts.lastSweep = time.Now()
+		go ts.Cleanup()
+	}
+	return ts.root, nil
+}
+
+func isTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (ts *TusServer) readUpload(root *os.Root, id string) (*TusUpload, error) {
+	b, err := root.ReadFile(id + ".info")
+	if err != nil {
+		return nil, err
+	}
+	tu := &TusUpload{}
+	if err := json.Unmarshal(b, tu); err != nil {
+		return nil, err
+	}
+	if !tu.Complete() && !tu.Expires.IsZero() && time.Now().After(tu.Expires) {
+		return nil, fs.ErrNotExist
+	}
+	return tu, nil
+}
+
+func (ts *TusServer) writeUpload(root *os.Root, tu *TusUpload) error {
+	b, err := json.Marshal(tu)
+	if err != nil {
+		return err
+	}
+	tmp := uploadTempName(".")
+	if err := root.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	err = root.Rename(tmp, tu.ID+".info")
+	if err != nil {
+		root.Remove(tmp)
+	}
+	return err
+}
+
+func (ts *TusServer) Cleanup() error {
+	root, err := ts.openRoot()
+	if err != nil {
+		return err
+	}
+	f, err := root.Open(".")
+	if err != nil {
+		return err
+	}
+	des, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, de := range des {
+		id, ok := strings.CutSuffix(de.Name(), ".info")
+		if !ok || !isTusID(id) {
+			continue
+		}
+		mtx, _ := ts.locks.LoadOrStore(id, &sync.Mutex{})
+		if !mtx.(*sync.Mutex).TryLock() {
+			continue
+		}
+		if _, err := ts.readUpload(root, id); errors.Is(err, fs.ErrNotExist) {
+			root.Remove(id + ".bin")
+			root.Remove(id + ".info")
+			ts.locks.Delete(id)
+		}
+		mtx.(*sync.Mutex).Unlock()
+	}
+	return nil
+}
+
+func parseTusMetadata(s string) (map[string]string, bool) {
+	md := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, " ")
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if len(k) == 0 || err != nil {
+			return nil, false
+		}
+		md[k] = string(b)
+	}
+	return md, true
+}
+
+func formatTusMetadata(md map[string]string) string {
+	var pairs []string
+	for k, v := range md {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (ts *TusServer) setExpires(w http.ResponseWriter, tu *TusUpload) {
+	if !tu.Expires.IsZero() && !tu.Complete() {
+		w.Header().Set("Upload-Expires", tu.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (ts *TusServer) unlock(mtx *sync.Mutex, tu *TusUpload) {
+	if tu.Complete() {
+		ts.locks.Delete(tu.ID)
+	}
+	mtx.Unlock()
+}
+
+func (ts *TusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id, ok := strings.CutPrefix(r.URL.Path, ts.BasePath)
+	if !ok {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+	id = strings.Trim(id, "/")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,creation-with-upload,termination,checksum,expiration")
+		w.Header().Set("Tus-Checksum-Algorithm", "sha1,sha256,md5")
+		if ts.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(ts.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		Error(w, r, http.StatusPreconditionFailed)
+		return
+	}
+
+	root, err := ts.openRoot()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if len(id) == 0 {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "OPTIONS, POST")
+			Error(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		ts.create(w, r, root)
+		return
+	}
+
+	if !isTusID(id) {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+
+	mtx, _ := ts.locks.LoadOrStore(id, &sync.Mutex{})
+	if !mtx.(*sync.Mutex).TryLock() {
+		Error(w, r, http.StatusLocked)
+		return
+	}
+
+	tu, err := ts.readUpload(root, id)
+	if err != nil {
+		ts.locks.Delete(id)
+		mtx.(*sync.Mutex).Unlock()
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+	defer ts.unlock(mtx.(*sync.Mutex), tu)
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(tu.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(tu.Size, 10))
+		if len(tu.Metadata) > 0 {
+			w.Header().Set("Upload-Metadata", formatTusMetadata(tu.Metadata))
+		}
+		ts.setExpires(w, tu)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		if mediaType(r.Header.Get("Content-Type")) != "application/offset+octet-stream" {
+			Error(w, r, http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			Error(w, r, http.StatusBadRequest)
+			return
+		}
+		if offset != tu.Offset {
+			Error(w, r, http.StatusConflict)
+			return
+		}
+		status := ts.patch(r, root, tu)
+		if status != http.StatusNoContent {
+			Error(w, r, status)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(tu.Offset, 10))
+		ts.setExpires(w, tu)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		root.Remove(id + ".bin")
+		root.Remove(id + ".info")
+		ts.locks.Delete(id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet:
+		if !tu.Complete() {
+			Error(w, r, http.StatusNotFound)
+			return
+		}
+		ts.serveUpload(w, r, root, tu)
+
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET, PATCH, DELETE")
+		Error(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+func (ts *TusServer) create(w http.ResponseWriter, r *http.Request, root *os.Root) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		Error(w, r, http.StatusBadRequest)
+		return
+	}
+	if ts.MaxSize > 0 && size > ts.MaxSize {
+		Error(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+	md, ok := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if !ok {
+		Error(w, r, http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	tu := &TusUpload{ID: hex.EncodeToString(b), Size: size, Metadata: md}
+	if ts.Expiration > 0 {
+		tu.Expires = time.Now().Add(ts.Expiration)
+	}
+
+	f, err := root.OpenFile(tu.ID+".bin", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err := ts.writeUpload(root, tu); err != nil {
+		root.Remove(tu.ID + ".bin")
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	mtx, _ := ts.locks.LoadOrStore(tu.ID, &sync.Mutex{})
+	mtx.(*sync.Mutex).Lock()
+	defer ts.unlock(mtx.(*sync.Mutex), tu)
+
+	if mediaType(r.Header.Get("Content-Type")) == "application/offset+octet-stream" {
+		if status := ts.patch(r, root, tu); status != http.StatusNoContent {
+			Error(w, r, status)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(tu.Offset, 10))
+	}
+	if tu.Size == 0 && ts.OnComplete != nil {
+		ts.OnComplete(r, tu)
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(ts.BasePath, "/")+"/"+tu.ID)
+	ts.setExpires(w, tu)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (ts *TusServer) patch(r *http.Request, root *os.Root, tu *TusUpload) int {
+	var h hash.Hash
+	var sum []byte
+	if cs := r.Header.Get("Upload-Checksum"); len(cs) > 0 {
+		algo, b64, _ := strings.Cut(cs, " ")
+		newHash, ok := tusChecksums[algo]
+		if !ok {
+			return http.StatusBadRequest
+		}
+		var err error
+		sum, err = base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+		if err != nil {
+			return http.StatusBadRequest
+		}
+		h = newHash()
+	}
+
+	f, err := root.OpenFile(tu.ID+".bin", os.O_WRONLY, 0644)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	defer f.Close()
+	if _, err := f.Seek(tu.Offset, io.SeekStart); err != nil {
+		return http.StatusInternalServerError
+	}
+
+	var dst io.Writer = f
+	if h != nil {
+		dst = io.MultiWriter(f, h)
+	}
+	n, err := io.Copy(dst, io.LimitReader(r.Body, tu.Size-tu.Offset))
+
+	if h != nil && (err != nil || !bytes.Equal(h.Sum(nil), sum)) {
+		f.Truncate(tu.Offset)
+		if err != nil {
+			return http.StatusInternalServerError
+		}
+		return tusChecksumMismatch
+	}
+
+	if n > 0 {
+		if serr := f.Sync(); serr != nil {
+			f.Truncate(tu.Offset)
+			return http.StatusInternalServerError
+		}
+		tu.Offset += n
+		if ts.Expiration > 0 {
+			tu.Expires = time.Now().Add(ts.Expiration)
+		}
+		if werr := ts.writeUpload(root, tu); werr != nil {
+			return http.StatusInternalServerError
+		}
+		if tu.Complete() && ts.OnComplete != nil {
+			ts.OnComplete(r, tu)
+		}
+	}
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusNoContent
+}
+
+func (ts *TusServer) serveUpload(w http.ResponseWriter, r *http.Request, root *os.Root, tu *TusUpload) {
+	f, err := root.Open(tu.ID + ".bin")
+	if err != nil {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+
+	disposition := "attachment"
+	if fileName := path.Base(strings.ReplaceAll(tu.Metadata["filename"], "\\", "/")); len(fileName) > 0 && fileName != "." && fileName != "/" {
+		if d := mime.FormatMediaType("attachment", map[string]string{"filename": fileName}); len(d) > 0 {
+			disposition = d
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", disposition)
+	fsv := &FileServer{CacheAge: ts.CacheAge}
+	fsv.serveFile(w, r, tu.ID, f, fi, nil)
+}
+
+func (ts *TusServer) Open(id string) (*os.File, *TusUpload, error) {
+	if !isTusID(id) {
+		return nil, nil, fs.ErrNotExist
+	}
+	root, err := ts.openRoot()
+	if err != nil {
+		return nil, nil, err
+	}
+	tu, err := ts.readUpload(root, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !tu.Complete() {
+		return nil, nil, fs.ErrNotExist
+	}
+	f, err := root.Open(id + ".bin")
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, tu, nil
+}
@@ -0,0 +1,164 @@
+package gotor
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type UnionLayer struct {
+	Name     string
+	Root     string
+	FS       fs.FS
+	Symlinks SymlinkPolicy
+}
+
+type unionLayerFS struct {
+	name string
+	fsys fs.FS
+}
+
+type UnionFS struct {
+	layers []unionLayerFS
+}
+
+func NewUnionFS(layers ...UnionLayer) (*UnionFS, error) {
+	ufs := &UnionFS{make([]unionLayerFS, 0, len(layers))}
+	for i, l := range layers {
+		fsys := l.FS
+		if fsys == nil {
+			var err error
+			fsys, err = DirFS(l.Root, l.Symlinks)
+			if err != nil {
+				return nil, err
+			}
+		}
+		name := l.Name
+		if len(name) == 0 {
+			name = l.Root
+		}
+		if len(name) == 0 {
+			name = strconv.Itoa(i)
+		}
+		ufs.layers = append(ufs.layers, unionLayerFS{name, fsys})
+	}
+	return ufs, nil
+}
+
+func UnionFileService(layers []UnionLayer, cacheAge int64, responseName bool, enableIndex bool) http.HandlerFunc {
+	fsv := &FileServer{
+		Layers:       layers,
+		CacheAge:     cacheAge,
+		ResponseName: responseName,
+		EnableIndex:  enableIndex,
+	}
+	return fsv.ServeHTTP
+}
+
+func (ufs *UnionFS) Open(name string) (fs.File, error) {
+	f, _, err := ufs.open(name)
+	return f, err
+}
+
+func (ufs *UnionFS) open(name string) (fs.File, *unionLayerFS, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	var ud *unionDir
+	for i, l := range ufs.layers {
+		f, err := l.fsys.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			if ud != nil {
+				continue
+			}
+			return nil, nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			if ud != nil {
+				continue
+			}
+			return nil, nil, err
+		}
+		if !fi.IsDir() {
+			if ud != nil {
+				f.Close()
+				continue
+			}
+			return f, &ufs.layers[i], nil
+		}
+		if ud == nil {
+			ud = &unionDir{File: f, ufs: ufs, name: name}
+		} else {
+			f.Close()
+		}
+		ud.layers = append(ud.layers, i)
+	}
+	if ud == nil {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return ud, &ufs.layers[ud.layers[0]], nil
+}
+
+func openLayered(fsys fs.FS, name string) (fs.File, *unionLayerFS, error) {
+	if ufs, ok := fsys.(*UnionFS); ok {
+		return ufs.open(name)
+	}
+	f, err := fsys.Open(name)
+	return f, nil, err
+}
+
+func (l *unionLayerFS) source(fsys fs.FS) fs.FS {
+	if l == nil {
+		return fsys
+	}
+	return l.fsys
+}
+
+type unionDir struct {
+	fs.File
+	ufs     *UnionFS
+	name    string
+	layers  []int
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (ud *unionDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !ud.read {
+		ud.read = true
+		seen := map[string]bool{}
+		for _, i := range ud.layers {
+			des, err := fs.ReadDir(ud.ufs.layers[i].fsys, ud.name)
+			if err != nil && len(des) == 0 {
+				continue
+			}
+			for _, de := range des {
+				if !seen[de.Name()] {
+					seen[de.Name()] = true
+					ud.entries = append(ud.entries, de)
+				}
+			}
+		}
+		sort.Slice(ud.entries, func(i, j int) bool { return ud.entries[i].Name() < ud.entries[j].Name() })
+	}
+	if n <= 0 {
+		des := ud.entries
+		ud.entries = nil
+		return des, nil
+	}
+	if len(ud.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(ud.entries))
+	des := ud.entries[:n]
+	ud.entries = ud.entries[n:]
+	return des, nil
+}
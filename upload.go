@@ -0,0 +1,338 @@
+package gotor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type CollisionPolicy int
+
+const (
+	CollisionOverwrite CollisionPolicy = iota
+	CollisionRename
+	CollisionReject
+)
+
+type UploadedFile struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+}
+
+type Uploader struct {
+	Root           string
+	MaxSize        int64
+	MaxRequestSize int64
+	MaxFiles       int
+	AllowedExts    []string
+	AllowedTypes   []string
+	Collision      CollisionPolicy
+	OnUpload       func(r *http.Request, f *UploadedFile) error
+
+	root    *os.Root
+	rootMtx sync.Mutex
+}
+
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (ue *uploadError) Error() string {
+	return ue.msg
+}
+
+var errUploadTooLarge = &uploadError{http.StatusRequestEntityTooLarge, "upload too large"}
+var errUploadType = &uploadError{http.StatusUnsupportedMediaType, "upload type not allowed"}
+var errUploadExists = &uploadError{http.StatusConflict, "upload target exists"}
+var errUploadName = &uploadError{http.StatusBadRequest, "invalid upload name"}
+
+const maxUploadParts = 1000
+const uploadFormOverhead = 1 << 20
+
+func uploadErrorStatus(err error) int {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		return ue.status
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (upl *Uploader) maxFiles() int {
+	if upl.MaxFiles > 0 {
+		return upl.MaxFiles
+	}
+	return 32
+}
+
+func (upl *Uploader) maxRequestSize() int64 {
+	if upl.MaxRequestSize > 0 {
+		return upl.MaxRequestSize
+	}
+	if upl.MaxSize <= 0 || upl.MaxSize > (math.MaxInt64-uploadFormOverhead)/int64(upl.maxFiles()) {
+		return 0
+	}
+	return upl.MaxSize*int64(upl.maxFiles()) + uploadFormOverhead
+}
+
+func (upl *Uploader) openRoot() (*os.Root, error) {
+	upl.rootMtx.Lock()
+	defer upl.rootMtx.Unlock()
+	if upl.root == nil {
+		root, err := os.OpenRoot(upl.Root)
+		if err != nil {
+			return nil, err
+		}
+		upl.root = root
+	}
+	return upl.root, nil
+}
+
+func matchContentType(allowed []string, contType string) bool {
+	mt := mediaType(contType)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if major, ok := strings.CutSuffix(a, "/*"); ok {
+			if strings.HasPrefix(mt, major+"/") {
+				return true
+			}
+		} else if a == mt || a == "*/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (upl *Uploader) checkExt(name string) bool {
+	if len(upl.AllowedExts) == 0 {
+		return true
+	}
+	ext := path.Ext(name)
+	for _, a := range upl.AllowedExts {
+		if strings.EqualFold(a, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func uploadTempName(dir string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return path.Join(dir, ".upload-"+hex.EncodeToString(b)+".tmp")
+}
+
+func renameCandidate(name string, i int) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(i) + ")" + ext
+}
+
+func (upl *Uploader) save(root *os.Root, name string, src io.Reader) (*UploadedFile, error) {
+	if name == "." || isDotfile(name) {
+		return nil, errUploadName
+	}
+	if !upl.checkExt(name) {
+		return nil, errUploadType
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	contType := http.DetectContentType(head)
+	if mt := mediaType(contType); mt == "application/octet-stream" || mt == "text/plain" {
+		if extType := mime.TypeByExtension(path.Ext(name)); len(extType) > 0 {
+			contType = extType
+		}
+	}
+	if len(upl.AllowedTypes) > 0 && !matchContentType(upl.AllowedTypes, contType) {
+		return nil, errUploadType
+	}
+
+	dir := path.Dir(name)
+	if dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp := uploadTempName(dir)
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Remove(tmp)
+
+	r := io.MultiReader(bytes.NewReader(head), src)
+	if upl.MaxSize > 0 {
+		r = io.LimitReader(r, upl.MaxSize+1)
+	}
+	size, err := io.Copy(f, r)
+	if err == nil && upl.MaxSize > 0 && size > upl.MaxSize {
+		err = errUploadTooLarge
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch upl.Collision {
+	case CollisionOverwrite:
+		err = root.Rename(tmp, name)
+	case CollisionReject:
+		err = root.Link(tmp, name)
+		if errors.Is(err, fs.ErrExist) {
+			err = errUploadExists
+		}
+	case CollisionRename:
+		target := name
+		for i := 1; ; i++ {
+			err = root.Link(tmp, target)
+			if !errors.Is(err, fs.ErrExist) {
+				break
+			}
+			target = renameCandidate(name, i)
+		}
+		name = target
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadedFile{Name: name, Size: size, ContentType: contType}, nil
+}
+
+func (upl *Uploader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	root, err := upl.openRoot()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if n := upl.maxRequestSize(); n > 0 {
+		if r.ContentLength > n {
+			Error(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+	}
+
+	files := []*UploadedFile{}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.URL.Path[len(r.URL.Path)-1] == '/' {
+			Error(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		if upl.MaxSize > 0 && r.ContentLength > upl.MaxSize {
+			Error(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		uf, err := upl.save(root, fsName(r.URL.Path), r.Body)
+		if err != nil {
+			Error(w, r, uploadErrorStatus(err))
+			return
+		}
+		files = append(files, uf)
+		if upl.OnUpload != nil {
+			if err := upl.OnUpload(r, uf); err != nil {
+				Error(w, r, uploadErrorStatus(err))
+				return
+			}
+		}
+
+	case http.MethodPost:
+		mr, err := r.MultipartReader()
+		if err != nil {
+			Error(w, r, http.StatusBadRequest)
+			return
+		}
+		dir := fsName(r.URL.Path)
+		for parts := 0; ; parts++ {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if _, rerr := r.Body.Read(nil); uploadErrorStatus(rerr) == http.StatusRequestEntityTooLarge {
+					Error(w, r, http.StatusRequestEntityTooLarge)
+				} else {
+					Error(w, r, http.StatusBadRequest)
+				}
+				return
+			}
+			if parts >= maxUploadParts {
+				part.Close()
+				Error(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			fileName := part.FileName()
+			if len(fileName) == 0 {
+				part.Close()
+				continue
+			}
+			if len(files) >= upl.maxFiles() {
+				part.Close()
+				Error(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			fileName = path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+			if fileName == "/" || fileName == "." || fileName == ".." {
+				part.Close()
+				Error(w, r, http.StatusBadRequest)
+				return
+			}
+			uf, err := upl.save(root, path.Join(dir, fileName), part)
+			part.Close()
+			if err != nil {
+				Error(w, r, uploadErrorStatus(err))
+				return
+			}
+			files = append(files, uf)
+			if upl.OnUpload != nil {
+				if err := upl.OnUpload(r, uf); err != nil {
+					Error(w, r, uploadErrorStatus(err))
+					return
+				}
+			}
+		}
+
+	default:
+		w.Header().Set("Allow", "POST, PUT")
+		Error(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(files)
+}
@@ -0,0 +1,122 @@
+package gotor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxVirtualHostEntries = 4096
+
+var errUnknownHost = errors.New("unknown virtual host")
+
+type VirtualHosts struct {
+	Pattern  string
+	Handler  func(root string) http.Handler
+	CacheTTL time.Duration
+
+	hosts     map[string]*vhostEntry
+	hostsMtx  sync.Mutex
+	lastSweep time.Time
+}
+
+type vhostEntry struct {
+	root    string
+	h       http.Handler
+	checked time.Time
+}
+
+func normalizeHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if len(host) == 0 || len(host) > 253 || host[0] == '.' || host[0] == '-' || strings.Contains(host, "..") {
+		return "", false
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '.' {
+			return "", false
+		}
+	}
+	return host, true
+}
+
+func (vh *VirtualHosts) cacheTTL() time.Duration {
+	if vh.CacheTTL > 0 {
+		return vh.CacheTTL
+	}
+	return time.Minute
+}
+
+func (vh *VirtualHosts) lookup(host string) (http.Handler, bool) {
+	host, ok := normalizeHost(host)
+	if !ok {
+		return nil, false
+	}
+
+	vh.hostsMtx.Lock()
+	defer vh.hostsMtx.Unlock()
+
+	now := time.Now()
+	ttl := vh.cacheTTL()
+	if vh.hosts == nil {
+		vh.hosts = map[string]*vhostEntry{}
+	}
+	if len(vh.hosts) >= maxVirtualHostEntries && now.Sub(vh.lastSweep) >= ttl {
+		vh.lastSweep = now
+		for k, e := range vh.hosts {
+			if e.h == nil && now.Sub(e.checked) >= ttl {
+				delete(vh.hosts, k)
+			}
+		}
+	}
+
+	e, ok := vh.hosts[host]
+	if ok && now.Sub(e.checked) < ttl {
+		return e.h, e.h != nil
+	}
+	if !ok {
+		e = &vhostEntry{root: strings.ReplaceAll(vh.Pattern, "{host}", host)}
+	}
+	e.checked = now
+	fi, err := os.Stat(e.root)
+	if err != nil || !fi.IsDir() {
+		e.h = nil
+		if !ok && len(vh.hosts) < maxVirtualHostEntries {
+			vh.hosts[host] = e
+		}
+		return nil, false
+	}
+	vh.hosts[host] = e
+	if e.h == nil {
+		if vh.Handler != nil {
+			e.h = vh.Handler(e.root)
+		} else {
+			e.h = &FileServer{Root: e.root, EnableIndex: true}
+		}
+	}
+	return e.h, true
+}
+
+func (vh *VirtualHosts) AllowCert(ctx context.Context, name string) error {
+	if _, ok := vh.lookup(name); !ok {
+		return errUnknownHost
+	}
+	return nil
+}
+
+func (vh *VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := vh.lookup(r.Host)
+	if !ok {
+		Error(w, r, http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,281 @@
+package gotor
+
+import (
+	"context"
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+type WebDAV struct {
+	Root       string
+	Prefix     string
+	Symlinks   SymlinkPolicy
+	OnRequest  func(r *http.Request, user *url.Userinfo) bool
+	FileServer *FileServer
+	LockSystem webdav.LockSystem
+	Logger     func(r *http.Request, err error)
+
+	h    *webdav.Handler
+	hMtx sync.Mutex
+}
+
+type rootDAVFS struct {
+	root         *os.Root
+	denySymlinks bool
+}
+
+func (rdfs *rootDAVFS) check(op string, name string) error {
+	if !rdfs.denySymlinks || name == "." {
+		return nil
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := checkNoSymlinks(rdfs.root, op, dir); err != nil {
+			return err
+		}
+	}
+	fi, err := rdfs.root.Lstat(name)
+	if err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (rdfs *rootDAVFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = fsName(name)
+	if err := rdfs.check("mkdir", name); err != nil {
+		return err
+	}
+	return rdfs.root.Mkdir(name, perm)
+}
+
+func (rdfs *rootDAVFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = fsName(name)
+	if err := rdfs.check("open", name); err != nil {
+		return nil, err
+	}
+	return rdfs.root.OpenFile(name, flag, perm)
+}
+
+func (rdfs *rootDAVFS) RemoveAll(ctx context.Context, name string) error {
+	name = fsName(name)
+	if name == "." {
+		return os.ErrInvalid
+	}
+	if err := rdfs.check("remove", name); err != nil {
+		return err
+	}
+	return rdfs.root.RemoveAll(name)
+}
+
+func (rdfs *rootDAVFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = fsName(oldName), fsName(newName)
+	if oldName == "." || newName == "." {
+		return os.ErrInvalid
+	}
+	if err := rdfs.check("rename", oldName); err != nil {
+		return err
+	}
+	if err := rdfs.check("rename", newName); err != nil {
+		return err
+	}
+	return rdfs.root.Rename(oldName, newName)
+}
+
+func (rdfs *rootDAVFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = fsName(name)
+	if err := rdfs.check("stat", name); err != nil {
+		return nil, err
+	}
+	return rdfs.root.Stat(name)
+}
+
+type davDeadProps struct {
+	props map[string]map[xml.Name]webdav.Property
+	mtx   sync.Mutex
+}
+
+func (ddp *davDeadProps) move(oldName, newName string) {
+	ddp.mtx.Lock()
+	defer ddp.mtx.Unlock()
+	for name, props := range ddp.props {
+		if name == oldName || strings.HasPrefix(name, oldName+"/") {
+			delete(ddp.props, name)
+			ddp.props[newName+name[len(oldName):]] = props
+		}
+	}
+}
+
+func (ddp *davDeadProps) remove(name string) {
+	ddp.mtx.Lock()
+	defer ddp.mtx.Unlock()
+	for n := range ddp.props {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			delete(ddp.props, n)
+		}
+	}
+}
+
+type davPropsFS struct {
+	webdav.FileSystem
+	deadProps *davDeadProps
+}
+
+type davPropsFile struct {
+	webdav.File
+	name      string
+	deadProps *davDeadProps
+}
+
+func (dpf *davPropsFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	dpf.deadProps.mtx.Lock()
+	defer dpf.deadProps.mtx.Unlock()
+	props := map[xml.Name]webdav.Property{}
+	for k, v := range dpf.deadProps.props[dpf.name] {
+		props[k] = v
+	}
+	return props, nil
+}
+
+func (dpf *davPropsFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	dpf.deadProps.mtx.Lock()
+	defer dpf.deadProps.mtx.Unlock()
+	props := dpf.deadProps.props[dpf.name]
+	if props == nil {
+		props = map[xml.Name]webdav.Property{}
+		dpf.deadProps.props[dpf.name] = props
+	}
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(props, p.XMLName)
+			} else {
+				props[p.XMLName] = p
+			}
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+func (dpfs *davPropsFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := dpfs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davPropsFile{f, fsName(name), dpfs.deadProps}, nil
+}
+
+func (dpfs *davPropsFS) RemoveAll(ctx context.Context, name string) error {
+	err := dpfs.FileSystem.RemoveAll(ctx, name)
+	if err == nil {
+		dpfs.deadProps.remove(fsName(name))
+	}
+	return err
+}
+
+func (dpfs *davPropsFS) Rename(ctx context.Context, oldName, newName string) error {
+	err := dpfs.FileSystem.Rename(ctx, oldName, newName)
+	if err == nil {
+		dpfs.deadProps.remove(fsName(newName))
+		dpfs.deadProps.move(fsName(oldName), fsName(newName))
+	}
+	return err
+}
+
+func (dav *WebDAV) handler() (*webdav.Handler, error) {
+	dav.hMtx.Lock()
+	defer dav.hMtx.Unlock()
+	if dav.h != nil {
+		return dav.h, nil
+	}
+
+	var dfs webdav.FileSystem
+	if dav.Symlinks == SymlinkFollowAll {
+		dfs = webdav.Dir(dav.Root)
+	} else {
+		root, err := os.OpenRoot(dav.Root)
+		if err != nil {
+			return nil, err
+		}
+		dfs = &rootDAVFS{root, dav.Symlinks == SymlinkDeny}
+	}
+
+	ls := dav.LockSystem
+	if ls == nil {
+		ls = webdav.NewMemLS()
+	}
+
+	dav.h = &webdav.Handler{
+		Prefix:     dav.Prefix,
+		FileSystem: &davPropsFS{dfs, &davDeadProps{props: map[string]map[xml.Name]webdav.Property{}}},
+		LockSystem: ls,
+		Logger:     dav.Logger,
+	}
+	if dav.FileServer == nil {
+		dav.FileServer = &FileServer{Root: dav.Root, Symlinks: dav.Symlinks, EnableIndex: true, CacheAge: -1}
+	}
+	return dav.h, nil
+}
+
+type davPrefixRespWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (dprw *davPrefixRespWriter) Unwrap() http.ResponseWriter {
+	return dprw.ResponseWriter
+}
+
+func (dprw *davPrefixRespWriter) WriteHeader(statusCode int) {
+	if statusCode >= 300 && statusCode < 400 {
+		if loc := dprw.Header().Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+			dprw.Header().Set("Location", dprw.prefix+loc)
+		}
+	}
+	dprw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (dav *WebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u := GetUser(r); dav.OnRequest != nil && !dav.OnRequest(r, u) {
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			Error(w, r, http.StatusUnauthorized)
+			return
+		}
+		Error(w, r, http.StatusForbidden)
+		return
+	}
+
+	h, err := dav.handler()
+	if err != nil {
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		p, ok := strings.CutPrefix(r.URL.Path, dav.Prefix)
+		if !ok {
+			Error(w, r, http.StatusNotFound)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + strings.TrimPrefix(p, "/")
+		r2.URL.RawPath = ""
+		dav.FileServer.ServeHTTP(&davPrefixRespWriter{w, strings.TrimSuffix(dav.Prefix, "/")}, r2)
+		return
+	}
+
+	h.ServeHTTP(w, r)
+}